@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Data formats.
+const (
+	DataFormatJSON = "json"
+	DataFormatYAML = "yaml"
+	DataFormatTOML = "toml"
+)
+
+// isDataFile returns true if path has an extension used by data files.
+func isDataFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".json", ".jsonc", ".yaml", ".yml", ".toml":
+		return true
+	default:
+		return false
+	}
+}
+
+// yamlKeyRegex matches a line beginning with a YAML mapping key.
+var yamlKeyRegex = regexp.MustCompile(`^[\w"'.-]+[ \t]*:([ \t]|$)`)
+
+// dataFormat returns the data format of a data file based on the extension
+// of its path or, if the extension is unknown, on its contents in buf.
+func dataFormat(path string, buf []byte) string {
+	switch filepath.Ext(path) {
+	case ".json", ".jsonc":
+		return DataFormatJSON
+	case ".yaml", ".yml":
+		return DataFormatYAML
+	case ".toml":
+		return DataFormatTOML
+	default:
+		return sniffDataFormat(buf)
+	}
+}
+
+// sniffDataFormat returns the data format of buf based on its first line
+// that is not blank or a # comment. Data starting with "{", "[", or a JSON
+// comment is JSON, and data starting with "---", "- ", or "key:" is YAML.
+// Defaults to DataFormatJSON.
+func sniffDataFormat(buf []byte) string {
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0 || line[0] == '#':
+			continue
+		case line[0] == '{' || line[0] == '[' || bytes.HasPrefix(line, []byte("//")) || bytes.HasPrefix(line, []byte("/*")):
+			return DataFormatJSON
+		case bytes.HasPrefix(line, []byte("---")) || bytes.HasPrefix(line, []byte("- ")) || yamlKeyRegex.Match(line):
+			return DataFormatYAML
+		}
+		break
+	}
+	return DataFormatJSON
+}
+
+// readDataFile reads the data file at path and decodes it based on its
+// extension or contents. Comments are always allowed in .jsonc files.
+func (m *Main) readDataFile(path string) (interface{}, error) {
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.decodeData(dataFormat(path, buf), buf, m.JSONC || filepath.Ext(path) == ".jsonc")
+}
+
+// readTemplateData reads the data file at TemplateDataPath relative to the
+// directory of the template at path.
+func (m *Main) readTemplateData(path string) (interface{}, error) {
+	return m.readValidDataFile(filepath.Join(filepath.Dir(path), m.TemplateDataPath))
+}
+
+// readValidDataFile reads the data file at path and validates it, if a
+// schema is set.
+func (m *Main) readValidDataFile(path string) (interface{}, error) {
+	data, err := m.readDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m.addInput(path)
+
+	if m.SchemaPath != "" {
+		if err := m.validateSchema(m.unnestData(data)); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return data, nil
+}
+
+// unnestData returns data as it was decoded, before being nested under
+// DataKey by decodeData. Data that is not nested is returned unchanged.
+func (m *Main) unnestData(data interface{}) interface{} {
+	if m.DataKey == "" {
+		return data
+	} else if v, ok := data.(map[string]interface{}); ok && len(v) == 1 {
+		if inner, ok := v[m.DataKey]; ok {
+			return inner
+		}
+	}
+	return data
+}
+
+// decodeData decodes buf in the given format, removing comments from JSON
+// if jsonc is true, and nests the result under DataKey, if set. The unparsed
+// buf is kept for the rawData function.
+func (m *Main) decodeData(format string, buf []byte, jsonc bool) (interface{}, error) {
+	m.raw = buf
+	if jsonc && format == DataFormatJSON {
+		buf = stripJSONComments(buf)
+	}
+
+	var v interface{}
+	if err := unmarshalData(format, buf, &v); err != nil {
+		return nil, err
+	} else if err := m.recordKeyOrder(format, buf, v); err != nil {
+		return nil, err
+	}
+
+	if m.DataKey != "" {
+		v = map[string]interface{}{m.DataKey: v}
+	}
+	return v, nil
+}
+
+// recordKeyOrder records the key order of the objects in v, decoded from
+// buf, if OrderedData is set and buf is JSON.
+func (m *Main) recordKeyOrder(format string, buf []byte, v interface{}) error {
+	if !m.OrderedData || format != DataFormatJSON {
+		return nil
+	}
+	if m.keyOrders == nil {
+		m.keyOrders = &keyOrders{}
+	}
+	return m.keyOrders.record(buf, v)
+}
+
+// unmarshalData decodes buf in the given format into v.
+func unmarshalData(format string, buf []byte, v *interface{}) error {
+	switch format {
+	case DataFormatJSON:
+		return json.Unmarshal(buf, v)
+	case DataFormatYAML:
+		return yaml.Unmarshal(buf, v)
+	case DataFormatTOML:
+		var m map[string]interface{}
+		if err := toml.Unmarshal(buf, &m); err != nil {
+			return err
+		}
+		*v = m
+		return nil
+	default:
+		return fmt.Errorf("unknown data format: %s", format)
+	}
+}
+
+// validateSchema validates data against the JSON Schema at SchemaPath.
+func (m *Main) validateSchema(data interface{}) error {
+	buf, err := m.FileReadWriter.ReadFile(m.SchemaPath)
+	if err != nil {
+		return err
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(buf), gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return fmt.Errorf("schema: %s", err)
+	} else if result.Valid() {
+		return nil
+	}
+
+	msgs := make([]string, len(result.Errors()))
+	for i, e := range result.Errors() {
+		msgs[i] = e.String()
+	}
+	return fmt.Errorf("data does not match schema %s:\n\t%s", m.SchemaPath, strings.Join(msgs, "\n\t"))
+}
+
+// stripJSONComments replaces // line comments and /* */ block comments in
+// JSON with spaces. Comment markers within strings are not affected.
+// Newlines are preserved so that parse errors report the original position.
+func stripJSONComments(b []byte) []byte {
+	other := make([]byte, len(b))
+	copy(other, b)
+
+	for i := 0; i < len(other); i++ {
+		switch {
+		case other[i] == '"':
+			// Skip over string, including escaped characters.
+			for i++; i < len(other) && other[i] != '"'; i++ {
+				if other[i] == '\\' {
+					i++
+				}
+			}
+
+		case other[i] == '/' && i+1 < len(other) && other[i+1] == '/':
+			for ; i < len(other) && other[i] != '\n'; i++ {
+				other[i] = ' '
+			}
+
+		case other[i] == '/' && i+1 < len(other) && other[i+1] == '*':
+			other[i], other[i+1] = ' ', ' '
+			for i += 2; i < len(other); i++ {
+				if other[i] == '*' && i+1 < len(other) && other[i+1] == '/' {
+					other[i], other[i+1] = ' ', ' '
+					i++
+					break
+				} else if other[i] != '\n' {
+					other[i] = ' '
+				}
+			}
+		}
+	}
+
+	return other
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// processDataDir renders TemplatePath once for each data file in DataDir.
+//
+// Each output is written to OutDir and named after its data file, with the
+// data file's extension replaced by OutSuffix and the extension of the
+// template's output path. For example, "models/user.json" rendered through
+// "model.go.tmpl" is written to "<OutDir>/user.go". Files in DataDir without
+// a data file extension are ignored. Returns an error if two data files
+// generate the same output path.
+func (m *Main) processDataDir() error {
+	// Read & parse template once for all data files.
+	path := m.TemplatePath
+	m.inputs = nil
+	r, perm, err := m.readTemplate(path)
+	if err != nil || r == nil {
+		return err
+	}
+	ext := filepath.Ext(strings.TrimSuffix(path, Extension))
+	base := len(m.inputs)
+
+	fis, err := m.OS.ReadDir(m.DataDir)
+	if err != nil {
+		return err
+	} else if err := m.OS.MkdirAll(m.OutDir, 0777); err != nil {
+		return err
+	}
+
+	paths := make(map[string]string)
+	for _, dataFI := range fis {
+		name := dataFI.Name()
+		if dataFI.IsDir() || !isDataFile(name) {
+			continue
+		}
+
+		// Ensure each data file is written to a separate file.
+		outputPath := filepath.Join(m.OutDir, strings.TrimSuffix(name, filepath.Ext(name))+m.OutSuffix+ext)
+		if other, ok := paths[outputPath]; ok {
+			return fmt.Errorf("-data-dir: %s and %s generate the same output path: %s", other, name, outputPath)
+		}
+		paths[outputPath] = name
+
+		m.inputs = m.inputs[:base]
+		data, err := m.readValidDataFile(filepath.Join(m.DataDir, name))
+		if err == nil {
+			err = m.generate(r, outputPath, data, perm)
+		}
+		if err := m.aggregateError(err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
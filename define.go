@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefineFilePrefix marks the names of defines that are written to their own
+// output with DefineFiles, e.g. "file:models.go".
+const DefineFilePrefix = "file:"
+
+// generateDefines writes each template defined within the template of r
+// whose name has DefineFilePrefix to its own output at the path following
+// the prefix, relative to the template's directory. Returns an error if no
+// file templates are defined, a path is outside the template's directory, or
+// two generate the same path.
+func (m *Main) generateDefines(r *Renderer, data interface{}, perm os.FileMode) error {
+	path := r.name
+	var names []string
+	for _, t := range r.tmpl.Templates() {
+		if t.Name() != r.tmpl.Name() && strings.HasPrefix(t.Name(), DefineFilePrefix) {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return fmt.Errorf("-define-files: no file templates defined: %s", path)
+	}
+
+	paths := make(map[string]string)
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, DefineFilePrefix)
+		if !isLocalPath(rel) {
+			return fmt.Errorf("-define-files: output path is outside the template directory: %q", name)
+		}
+		outputPath := filepath.Join(filepath.Dir(path), rel)
+
+		// Ensure each define is written to a separate file.
+		if other, ok := paths[outputPath]; ok {
+			return fmt.Errorf("-define-files: %q and %q generate the same output path: %s", other, name, outputPath)
+		}
+		paths[outputPath] = name
+
+		if err := m.generate(r.lookup(name), outputPath, data, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
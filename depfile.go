@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// dependency is a generated file and the files it was generated from.
+type dependency struct {
+	target  string
+	prereqs []string
+}
+
+// addInput records that the outputs currently being generated depend on the
+// file at path.
+func (m *Main) addInput(path string) {
+	if m.DepfilePath != "" || m.Watch {
+		m.inputs = append(m.inputs, path)
+	}
+}
+
+// addDependency records the dependencies of the output written to
+// outputPath: the data files, the prelude, and the inputs read since the
+// template was read, in order and without duplicates.
+func (m *Main) addDependency(outputPath string) {
+	if m.DepfilePath == "" {
+		return
+	}
+
+	var prereqs []string
+	seen := make(map[string]bool)
+	for _, paths := range [][]string{m.DataPaths, {m.PreludePath}, m.inputs} {
+		for _, path := range paths {
+			if path != "" && !seen[path] {
+				prereqs, seen[path] = append(prereqs, path), true
+			}
+		}
+	}
+	m.dependencies = append(m.dependencies, dependency{target: outputPath, prereqs: prereqs})
+}
+
+// writeDepfile writes a Makefile rule for each generated file listing its
+// dependencies to DepfilePath.
+func (m *Main) writeDepfile() error {
+	var buf bytes.Buffer
+	for _, dep := range m.dependencies {
+		buf.WriteString(escapeMakePath(dep.target) + ":")
+		for _, path := range dep.prereqs {
+			buf.WriteString(" " + escapeMakePath(path))
+		}
+		buf.WriteString("\n")
+	}
+	return m.writeOutput(m.DepfilePath, buf.Bytes(), 0666)
+}
+
+// readDepfile returns the dependencies of each target in the depfile written
+// to DepfilePath by a previous run. Returns nil if there is no depfile.
+func (m *Main) readDepfile() (map[string][]string, error) {
+	buf, err := m.FileReadWriter.ReadFile(m.DepfilePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	deps := make(map[string][]string)
+	for _, line := range strings.Split(string(buf), "\n") {
+		paths := splitMakePaths(line)
+		if len(paths) == 0 || !strings.HasSuffix(paths[0], ":") {
+			continue
+		}
+		deps[strings.TrimSuffix(paths[0], ":")] = paths[1:]
+	}
+	return deps, nil
+}
+
+// splitMakePaths splits a Makefile rule into its space-separated paths,
+// reversing the escaping of escapeMakePath.
+func splitMakePaths(line string) []string {
+	var paths []string
+	var buf bytes.Buffer
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '#'):
+			buf.WriteByte(line[i+1])
+			i++
+		case c == '$' && i+1 < len(line) && line[i+1] == '$':
+			buf.WriteByte('$')
+			i++
+		case c == ' ':
+			if buf.Len() > 0 {
+				paths = append(paths, buf.String())
+				buf.Reset()
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if buf.Len() > 0 {
+		paths = append(paths, buf.String())
+	}
+	return paths
+}
+
+// escapeMakePath escapes the characters in path that are special in
+// Makefile rules.
+func escapeMakePath(path string) string {
+	return strings.NewReplacer(" ", `\ `, "#", `\#`, "$", "$$").Replace(path)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DiffContext is the number of unchanged lines shown around each change in
+// a unified diff.
+const DiffContext = 3
+
+// printDiff writes a unified diff of the change from the existing file at
+// path to output to Stdout. A missing file is diffed as /dev/null.
+func (m *Main) printDiff(path string, output []byte) error {
+	oldName := path
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if os.IsNotExist(err) {
+		oldName = "/dev/null"
+	} else if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprint(m.Stdout, unifiedDiff(oldName, path, string(buf), string(output)))
+	return err
+}
+
+// diffOp is a single line of a diff: an unchanged line (' '), a removed
+// line ('-'), or an added line ('+'). Lines include their newline, if any.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns a unified diff of the change from a, named oldName,
+// to b, named newName. Returns an empty string if a and b are equal.
+func unifiedDiff(oldName, newName, a, b string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var buf strings.Builder
+	for i := 0; i < len(ops); {
+		// Find the next change, and the end of the run of changes that are
+		// close enough to share context.
+		start := i
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end := start
+		for j := start; j < len(ops) && j-end <= 2*DiffContext; j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			}
+		}
+
+		lo, hi := start-DiffContext, end+DiffContext
+		if lo < i {
+			lo = i
+		}
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		writeHunk(&buf, ops, lo, hi)
+		i = hi
+	}
+	return buf.String()
+}
+
+// writeHunk writes the hunk of ops from lo to hi, with its header, to buf.
+func writeHunk(buf *strings.Builder, ops []diffOp, lo, hi int) {
+	// Determine the line numbers at which the hunk starts in each file.
+	oldLine, newLine := 1, 1
+	for _, op := range ops[:lo] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+
+	var oldCount, newCount int
+	for _, op := range ops[lo:hi] {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+
+	for _, op := range ops[lo:hi] {
+		buf.WriteByte(op.kind)
+		buf.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of count lines starting at line for a hunk
+// header. An empty range refers to the line before it.
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprint(line)
+	default:
+		return fmt.Sprintf("%d,%d", line, count)
+	}
+}
+
+// splitLines splits s into lines, each including its newline. The final
+// line has no newline if s does not end with one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest sequence of operations that changes the
+// lines of a into those of b. Common leading & trailing lines are trimmed
+// before diffing the remainder with Myers' algorithm.
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// maxDiffCost limits the number of changed lines that myersDiff searches
+// for, as its memory use is quadratic in the number of changes.
+const maxDiffCost = 1000
+
+// myersDiff returns the shortest sequence of operations that changes a into
+// b using Myers' O(ND) algorithm. If more than maxDiffCost lines change then
+// all of a is removed and all of b is added instead.
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+
+	// v holds the furthest x reached on each diagonal k = x - y, offset by
+	// off so that negative diagonals can be indexed. The diagonals around
+	// the search are kept before each step so that the path can be traced
+	// back, with those for step d at trace[d][k+d+1].
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; ; d++ {
+		if d > maxDiffCost {
+			return replaceLines(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			done = x >= n && y >= m
+		}
+		if done {
+			break
+		}
+	}
+
+	// Trace the path back from the end, collecting operations in reverse.
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[k-1+d+1] < v[k+1+d+1]) {
+			prevK = k + 1
+		}
+		prevX := v[prevK+d+1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x, y = x-1, y-1
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceLines returns operations that remove every line of a and add every
+// line of b.
+func replaceLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// isUTF8 returns true if outputs are written as UTF-8, without transcoding.
+func (m *Main) isUTF8() bool {
+	return m.Encoding == "" || strings.EqualFold(m.Encoding, "utf-8") || strings.EqualFold(m.Encoding, "utf8")
+}
+
+// outputEncoding returns the encoding named by Encoding, or nil if outputs
+// are written as UTF-8.
+func (m *Main) outputEncoding() (encoding.Encoding, error) {
+	if m.isUTF8() {
+		return nil, nil
+	}
+
+	enc, err := ianaindex.IANA.Encoding(m.Encoding)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unsupported -encoding value: %s", m.Encoding)
+	}
+	return enc, nil
+}
+
+// decode transcodes the existing output buf from Encoding to UTF-8, if set,
+// so that its text can be searched. Returns buf unchanged if it cannot be
+// decoded.
+func (m *Main) decode(buf []byte) []byte {
+	enc, err := m.outputEncoding()
+	if err != nil || enc == nil {
+		return buf
+	}
+	if decoded, err := enc.NewDecoder().Bytes(buf); err == nil {
+		return decoded
+	}
+	return buf
+}
+
+// encode transcodes the UTF-8 output for outputPath to Encoding, if set.
+// Returns an error identifying the first character that the encoding cannot
+// represent.
+func (m *Main) encode(outputPath string, output []byte) ([]byte, error) {
+	enc, err := m.outputEncoding()
+	if err != nil || enc == nil {
+		return output, err
+	}
+
+	encoded, err := enc.NewEncoder().Bytes(output)
+	if err == nil {
+		return encoded, nil
+	}
+
+	// Find the character that could not be encoded to report its position.
+	for i, line := range bytes.Split(output, []byte("\n")) {
+		for col := 0; col < len(line); {
+			r, size := utf8.DecodeRune(line[col:])
+			if _, err := enc.NewEncoder().Bytes(line[col : col+size]); err != nil {
+				return nil, fmt.Errorf("%s:%d:%d: cannot encode %q as %s", outputPath, i+1, col+1, r, m.Encoding)
+			}
+			col += size
+		}
+	}
+	return nil, fmt.Errorf("%s: cannot encode as %s: %s", outputPath, m.Encoding, err)
+}
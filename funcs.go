@@ -0,0 +1,1324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"math"
+	"math/big"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Masterminds/sprig"
+	"github.com/dustin/go-humanize/english"
+	"github.com/ghodss/yaml"
+)
+
+// GoInitialisms are the initialisms that goName writes in all caps, taken
+// from golint's list of common initialisms.
+var GoInitialisms = []string{
+	"ACL", "API", "ASCII", "CPU", "CSS", "DNS", "EOF", "GUID", "HTML", "HTTP",
+	"HTTPS", "ID", "IP", "JSON", "LHS", "QPS", "RAM", "RHS", "RPC", "SLA",
+	"SMTP", "SQL", "SSH", "TCP", "TLS", "TTL", "UDP", "UI", "UID", "UUID",
+	"URI", "URL", "UTF8", "VM", "XML", "XMPP", "XSRF", "XSS",
+}
+
+// randMu serializes sprig's random string functions, which share a random
+// source that is not safe for concurrent use.
+var randMu sync.Mutex
+
+// funcMap returns the functions available to templates.
+func (m *Main) funcMap() template.FuncMap {
+	funcMap := sprig.TxtFuncMap()
+	for _, name := range []string{"randAlpha", "randAlphaNum", "randAscii", "randNumeric"} {
+		funcMap[name] = syncRandFunc(funcMap[name].(func(int) string))
+	}
+	funcMap["pluralize"] = pluralize
+	funcMap["sublist"] = sublist
+	funcMap["sortBy"] = sortBy
+	funcMap["keys"] = keys
+	funcMap["values"] = values
+	funcMap["toYAML"] = toYAML
+	funcMap["fromYAML"] = fromYAML
+	funcMap["uuid"] = m.uuid
+	funcMap["enumerate"] = enumerate
+	funcMap["enumerateFrom"] = enumerateFrom
+	funcMap["ordered"] = m.ordered
+	funcMap["required"] = required
+	funcMap["skipFile"] = skipFile
+	funcMap["fileContents"] = m.fileContents
+	funcMap["rawData"] = m.rawData
+	funcMap["fromJSONFile"] = m.dataFileFunc(DataFormatJSON)
+	funcMap["fromYAMLFile"] = m.dataFileFunc(DataFormatYAML)
+	funcMap["fromTOMLFile"] = m.dataFileFunc(DataFormatTOML)
+	funcMap["fromDataFile"] = m.dataFileFunc("")
+	funcMap["jsonPointer"] = jsonPointer
+	funcMap["concat"] = concat
+	funcMap["sprint"] = fmt.Sprint
+	funcMap["sprintf"] = fmt.Sprintf
+	funcMap["relPath"] = relPath
+	funcMap["pathJoin"] = pathJoin
+	funcMap["stripComments"] = stripComments
+	funcMap["jsonEscape"] = jsonEscape
+	funcMap["yamlEscape"] = yamlEscape
+	funcMap["reflow"] = reflow
+	funcMap["reflowComment"] = reflowComment
+	funcMap["lines"] = lines
+	funcMap["head"] = head
+	funcMap["hasItem"] = hasItem
+	funcMap["isSet"] = isSet
+	funcMap["in"] = in
+	funcMap["goName"] = m.goName
+	funcMap["goIdent"] = goIdent
+	funcMap["goTag"] = goTag
+	funcMap["goLiteral"] = goLiteral
+	funcMap["goEnum"] = goEnum
+	funcMap["goHexLiteral"] = goHexLiteral
+	funcMap["hex"] = formatIntFunc("hex", 16)
+	funcMap["oct"] = formatIntFunc("oct", 8)
+	funcMap["bin"] = formatIntFunc("bin", 2)
+	funcMap["parseDuration"] = parseDuration
+	funcMap["formatDuration"] = formatDuration
+	funcMap["parseBytes"] = parseBytes
+	funcMap["formatBytes"] = formatBytes
+	funcMap["env"] = m.env
+	funcMap["meta"] = m.meta
+	funcMap["args"] = m.args
+	funcMap["tail"] = tail
+	funcMap["numEq"] = numCompareFunc(func(a, b float64) bool { return a == b })
+	funcMap["numNe"] = numCompareFunc(func(a, b float64) bool { return a != b })
+	funcMap["numLt"] = numCompareFunc(func(a, b float64) bool { return a < b })
+	funcMap["numLe"] = numCompareFunc(func(a, b float64) bool { return a <= b })
+	funcMap["numGt"] = numCompareFunc(func(a, b float64) bool { return a > b })
+	funcMap["numGe"] = numCompareFunc(func(a, b float64) bool { return a >= b })
+	funcMap["numAdd"] = arithFunc("numAdd")
+	funcMap["numMul"] = arithFunc("numMul")
+	funcMap["numSub"] = binaryArithFunc(arithFunc("numSub"))
+	funcMap["numDiv"] = binaryArithFunc(arithFunc("numDiv"))
+	funcMap["numMod"] = binaryArithFunc(arithFunc("numMod"))
+	for name, fn := range m.Funcs {
+		funcMap[name] = fn
+	}
+	return funcMap
+}
+
+// errSkipFile is returned by skipFile to stop rendering the current output.
+var errSkipFile = errors.New("skipFile called")
+
+// skipFile stops execution of the template so that no output is written.
+func skipFile() (string, error) {
+	return "", errSkipFile
+}
+
+// isSkipFile returns true if err was caused by calling skipFile.
+func isSkipFile(err error) bool {
+	return errors.Is(err, errSkipFile)
+}
+
+// syncRandFunc returns fn wrapped to hold randMu while it is called.
+func syncRandFunc(fn func(int) string) func(int) string {
+	return func(count int) string {
+		randMu.Lock()
+		defer randMu.Unlock()
+		return fn(count)
+	}
+}
+
+// executeTemplateFunc returns a function that executes a template from the
+// set associated with tmpl by name and returns its output.
+func executeTemplateFunc(tmpl *template.Template) func(name string, data interface{}) (string, error) {
+	return func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
+
+// uuid returns a random version 4 UUID generated from Rand.
+func (m *Main) uuid() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(m.Rand, b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// fileContents returns the contents of the file at path with leading and
+// trailing whitespace removed.
+func (m *Main) fileContents(path string) (string, error) {
+	if err := m.checkReadAllowed(path); err != nil {
+		return "", err
+	}
+
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	m.addInput(path)
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// rawData returns the unparsed contents of the data source, such as the data
+// file, that the template's data was decoded from.
+func (m *Main) rawData() (string, error) {
+	if m.raw == nil {
+		return "", errors.New("rawData: data was not decoded from a single source")
+	}
+	return string(m.raw), nil
+}
+
+// dataFileFunc returns a function that reads & decodes the data file at a
+// path in format or, if format is blank, the format of its extension. The
+// result is not nested under DataKey. Comments are allowed in JSON data as
+// they are in data files.
+func (m *Main) dataFileFunc(format string) func(path string) (interface{}, error) {
+	return func(path string) (interface{}, error) {
+		if err := m.checkReadAllowed(path); err != nil {
+			return nil, err
+		}
+
+		buf, err := m.FileReadWriter.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m.addInput(path)
+
+		format := format
+		if format == "" {
+			format = dataFormat(path, buf)
+		}
+		if format == DataFormatJSON && (m.JSONC || filepath.Ext(path) == ".jsonc") {
+			buf = stripJSONComments(buf)
+		}
+
+		var v interface{}
+		if err := unmarshalData(format, buf, &v); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		} else if err := m.recordKeyOrder(format, buf, v); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return v, nil
+	}
+}
+
+// checkReadAllowed returns an error if AllowedReadDirs is set and path is not
+// within one of the directories. Symbolic links are not resolved.
+func (m *Main) checkReadAllowed(path string) error {
+	if len(m.AllowedReadDirs) == 0 {
+		return nil
+	}
+
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range m.AllowedReadDirs {
+		absdir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(absdir, abspath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("read not allowed outside of -allow-read directories: %s", path)
+}
+
+func pluralize(s string) string {
+	return english.PluralWord(2, s, "")
+}
+
+// concat formats each value with %v and joins them without a separator. It
+// is the counterpart to sprig's cat, which separates values with spaces.
+func concat(v ...interface{}) string {
+	var buf bytes.Buffer
+	for _, x := range v {
+		fmt.Fprintf(&buf, "%v", x)
+	}
+	return buf.String()
+}
+
+// relPath returns the path of target relative to base, using forward
+// slashes. Both paths must be absolute or both relative.
+func relPath(base, target string) (string, error) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// pathJoin joins elements with forward slashes, such as a module path and a
+// package directory, and cleans the result. Empty elements are ignored.
+func pathJoin(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Comment styles supported by stripComments.
+const (
+	CommentStyleGo    = "go"
+	CommentStyleC     = "c"
+	CommentStyleShell = "shell"
+)
+
+// stripComments removes comments from the source s. The go and c styles
+// remove // line and /* */ block comments, and the shell style removes #
+// comments that begin a word, keeping a leading #! line. Comment markers
+// within quoted strings, and Go raw strings, are not affected.
+//
+// A block comment spanning lines is replaced by a single line break.
+// Trailing whitespace is trimmed from lines that contained a comment and
+// those left blank are removed. Other lines are unchanged.
+func stripComments(style, s string) (string, error) {
+	var quotes, lineComment string
+	var blockComments bool
+	switch style {
+	case CommentStyleGo:
+		quotes, lineComment, blockComments = "\"'`", "//", true
+	case CommentStyleC:
+		quotes, lineComment, blockComments = "\"'", "//", true
+	case CommentStyleShell:
+		quotes, lineComment = "\"'", "#"
+	default:
+		return "", fmt.Errorf("stripComments: unknown style %q, expected go, c, or shell", style)
+	}
+
+	var buf strings.Builder
+	commented := make(map[int]bool) // output lines that contained a comment
+	line := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case style == CommentStyleShell && i == 0 && strings.HasPrefix(s, "#!"):
+			// Keep the interpreter line.
+			n := strings.IndexByte(s, '\n')
+			if n == -1 {
+				n = len(s)
+			}
+			buf.WriteString(s[:n])
+			i = n
+
+		case strings.IndexByte(quotes, c) != -1:
+			// Copy the string up to its closing quote. Single-quoted shell
+			// strings & raw strings have no escapes and may span lines.
+			escapes := c != '`' && !(style == CommentStyleShell && c == '\'')
+			multiline := !escapes || style == CommentStyleShell
+			j := i + 1
+			for ; j < len(s) && s[j] != c && (multiline || s[j] != '\n'); j++ {
+				if escapes && s[j] == '\\' {
+					j++
+				}
+			}
+			if j < len(s) && s[j] == c {
+				j++
+			} else if j > len(s) {
+				j = len(s)
+			}
+			line += strings.Count(s[i:j], "\n")
+			buf.WriteString(s[i:j])
+			i = j
+
+		case strings.HasPrefix(s[i:], lineComment) && (style != CommentStyleShell || i == 0 || strings.IndexByte(" \t\n;|&(", s[i-1]) != -1):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			commented[line] = true
+
+		case blockComments && strings.HasPrefix(s[i:], "/*"):
+			n := strings.Index(s[i+2:], "*/")
+			j := len(s)
+			if n != -1 {
+				j = i + n + 4
+			}
+			commented[line] = true
+
+			// Keep a line break in place of a comment spanning lines so
+			// that the code on either side stays on separate lines.
+			if strings.Contains(s[i:j], "\n") {
+				buf.WriteByte('\n')
+				line++
+				commented[line] = true
+			}
+			i = j
+
+		default:
+			if c == '\n' {
+				line++
+			}
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	// Remove lines that only contained comments.
+	var other []string
+	for i, text := range strings.Split(buf.String(), "\n") {
+		if commented[i] {
+			if text = strings.TrimRight(text, " \t"); text == "" {
+				continue
+			}
+		}
+		other = append(other, text)
+	}
+	return strings.Join(other, "\n"), nil
+}
+
+// jsonEscape escapes s for use within a double-quoted JSON string. The
+// surrounding quotes are not included. HTML characters are not escaped.
+func jsonEscape(s string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return "", err
+	}
+	out := strings.TrimSuffix(buf.String(), "\n")
+	return out[1 : len(out)-1], nil
+}
+
+// yamlEscape escapes s for use within a double-quoted YAML string. The
+// surrounding quotes are not included. Characters that YAML does not allow
+// unescaped, such as control characters, are written as escape sequences.
+func yamlEscape(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\u0085':
+			buf.WriteString(`\N`)
+		case '\u2028':
+			buf.WriteString(`\L`)
+		case '\u2029':
+			buf.WriteString(`\P`)
+		case '\ufeff':
+			buf.WriteString(`\uFEFF`)
+		default:
+			if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+				fmt.Fprintf(&buf, `\x%02X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// reflow reflows s so that its lines are at most width characters long.
+// Paragraphs, separated by blank lines, are reflowed separately and other
+// newlines are treated as spaces. Words longer than width are written on a
+// line of their own rather than broken.
+func reflow(width int, s string) (string, error) {
+	if width < 1 {
+		return "", fmt.Errorf("reflow: width must be positive, got %d", width)
+	}
+	return strings.Join(wrapLines(width, s), "\n"), nil
+}
+
+// reflowComment reflows the // comment s in the same way as reflow, such that
+// lines are at most width characters long including their // prefix. The
+// existing prefix, and the indentation of the first line, are kept on each
+// line. Text without a prefix becomes a comment.
+func reflowComment(width int, s string) (string, error) {
+	indent := ""
+	if m := commentPrefixRegex.FindStringSubmatch(s); m != nil {
+		indent = m[1]
+	}
+
+	// Strip existing prefixes & wrap the remaining text.
+	text := strings.Split(s, "\n")
+	for i := range text {
+		text[i] = commentPrefixRegex.ReplaceAllString(text[i], "")
+	}
+	n := width - utf8.RuneCountInString(indent+"// ")
+	if n < 1 {
+		return "", fmt.Errorf("reflowComment: width %d is too small for the comment prefix", width)
+	}
+
+	other := wrapLines(n, strings.Join(text, "\n"))
+	for i, line := range other {
+		if line != "" {
+			other[i] = indent + "// " + line
+		} else if i < len(other)-1 {
+			other[i] = indent + "//"
+		}
+	}
+	return strings.Join(other, "\n"), nil
+}
+
+// commentPrefixRegex matches the indentation & // prefix of a comment line.
+var commentPrefixRegex = regexp.MustCompile(`^([ \t]*)// ?`)
+
+// wrapLines reflows s into lines of at most width characters. Blank lines
+// are kept, as is a trailing newline, which becomes a final empty line.
+func wrapLines(width int, s string) []string {
+	var other, words []string
+	flush := func() {
+		var line string
+		for _, word := range words {
+			if line == "" {
+				line = word
+			} else if utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width {
+				line += " " + word
+			} else {
+				other, line = append(other, line), word
+			}
+		}
+		if line != "" {
+			other = append(other, line)
+		}
+		words = nil
+	}
+
+	text := strings.Split(s, "\n")
+	for i, line := range text {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			words = append(words, fields...)
+			continue
+		}
+		flush()
+		if i < len(text)-1 {
+			other = append(other, "")
+		}
+	}
+	flush()
+	if strings.HasSuffix(s, "\n") {
+		other = append(other, "")
+	}
+	return other
+}
+
+// lines splits s into lines without their line terminators. A trailing
+// newline does not start an additional line, so "a\nb\n" and "a\nb" both
+// return two lines. An empty string returns no lines.
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// head returns the first n lines of s, including line terminators.
+// Returns s if it has n lines or fewer.
+func head(n int, s string) string {
+	i := 0
+	for ; n > 0; n-- {
+		j := strings.Index(s[i:], "\n")
+		if j < 0 {
+			return s
+		}
+		i += j + 1
+	}
+	return s[:i]
+}
+
+// tail returns the last n lines of s, including line terminators. A trailing
+// newline does not start an additional line. Returns s if it has n lines or
+// fewer.
+func tail(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+
+	// Trim lines from the end of prefix until it precedes the last n lines.
+	prefix := strings.TrimSuffix(s, "\n")
+	for ; n > 0; n-- {
+		i := strings.LastIndex(prefix, "\n")
+		if i < 0 {
+			return s
+		}
+		prefix = prefix[:i]
+	}
+	return s[len(prefix)+1:]
+}
+
+// sublist returns the elements of a slice from index i up to, but excluding,
+// index j. Indices are clamped to the bounds of the slice so out-of-range
+// indices return an empty result instead of failing.
+func sublist(v interface{}, i, j int) []interface{} {
+	a := toList(v)
+	i, j = clampRange(i, j, len(a))
+	return a[i:j]
+}
+
+// sortBy returns a copy of a slice of maps or structs stably sorted by the
+// value of the given key or field. Numeric values are compared numerically
+// and all other values are compared by their string representation.
+func sortBy(key string, v interface{}) []interface{} {
+	a := toList(v)
+	if a == nil {
+		return []interface{}{}
+	}
+
+	sort.SliceStable(a, func(i, j int) bool {
+		return lessValue(fieldValue(a[i], key), fieldValue(a[j], key))
+	})
+	return a
+}
+
+// keys returns the combined keys of the maps in vs in sorted order, as a
+// []string if every key is a string like sprig's keys, which it replaces.
+// Arguments that are not maps have no keys.
+func keys(vs ...interface{}) interface{} {
+	a := []interface{}{}
+	for _, v := range vs {
+		for _, k := range sortedMapKeys(v) {
+			a = append(a, k.Interface())
+		}
+	}
+	sort.SliceStable(a, func(i, j int) bool { return lessValue(a[i], a[j]) })
+
+	// Return string keys as a []string, as sprig does.
+	strs := make([]string, len(a))
+	for i, k := range a {
+		s, ok := k.(string)
+		if !ok {
+			return a
+		}
+		strs[i] = s
+	}
+	return strs
+}
+
+// values returns the values of a map, ordered by their sorted keys.
+// Returns an empty slice if v is not a map.
+func values(v interface{}) []interface{} {
+	a := []interface{}{}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for _, k := range sortedMapKeys(v) {
+		a = append(a, rv.MapIndex(k).Interface())
+	}
+	return a
+}
+
+// sortedMapKeys returns the keys of a map in sorted order.
+// Returns nil if v is not a map.
+func sortedMapKeys(v interface{}) []reflect.Value {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Map {
+		return nil
+	}
+
+	a := rv.MapKeys()
+	sort.Slice(a, func(i, j int) bool {
+		return lessValue(a[i].Interface(), a[j].Interface())
+	})
+	return a
+}
+
+// EnumItem is an element returned by the enumerate functions.
+type EnumItem struct {
+	Index int
+	Key   interface{} // map key; nil for slices
+	Value interface{}
+}
+
+// enumerate returns the elements of a slice or map paired with a zero-based
+// index. Maps are enumerated in sorted key order.
+func enumerate(v interface{}) []EnumItem {
+	return enumerateFrom(0, v)
+}
+
+// enumerateFrom returns the elements of a slice or map paired with an index
+// counting up from start. Maps are enumerated in sorted key order.
+func enumerateFrom(start int, v interface{}) []EnumItem {
+	a := []EnumItem{}
+	if rv := reflect.Indirect(reflect.ValueOf(v)); rv.Kind() == reflect.Map {
+		for i, k := range sortedMapKeys(v) {
+			a = append(a, EnumItem{Index: start + i, Key: k.Interface(), Value: rv.MapIndex(k).Interface()})
+		}
+		return a
+	}
+
+	for i, e := range toList(v) {
+		a = append(a, EnumItem{Index: start + i, Value: e})
+	}
+	return a
+}
+
+// required returns v or, if v is nil or empty, an error with msg.
+// Empty strings, slices, and maps are considered empty.
+func required(msg string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New(msg)
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return nil, errors.New(msg)
+		}
+	}
+	return v, nil
+}
+
+// meta returns the build metadata, which is never nil.
+func (m *Main) meta() map[string]string {
+	if m.Meta == nil {
+		return map[string]string{}
+	}
+	return m.Meta
+}
+
+// args returns the extra command line arguments, which is never nil.
+func (m *Main) args() []string {
+	if m.Args == nil {
+		return []string{}
+	}
+	return m.Args
+}
+
+// env returns the value of the environment variable name. If the variable is
+// unset or empty then the optional default value is returned instead.
+func (m *Main) env(name string, def ...string) (string, error) {
+	if len(def) > 1 {
+		return "", fmt.Errorf("env: expected at most one default value, got %d", len(def))
+	} else if v := m.OS.Getenv(name); v != "" {
+		return v, nil
+	} else if len(def) == 1 {
+		return def[0], nil
+	}
+	return "", nil
+}
+
+// goName converts s to an exported Go identifier. Words are separated by
+// non-alphanumeric characters or a lowercase letter followed by an uppercase
+// letter. Words that are initialisms, such as "id", are written in all caps
+// so that "user_id" becomes "UserID".
+func (m *Main) goName(s string) string {
+	var words []string
+	var word []rune
+	var prev rune
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			words, word = appendWord(words, word), nil
+		} else if unicode.IsUpper(r) && unicode.IsLower(prev) {
+			words, word = appendWord(words, word), []rune{r}
+		} else {
+			word = append(word, r)
+		}
+		prev = r
+	}
+	words = appendWord(words, word)
+
+	var buf bytes.Buffer
+	for _, w := range words {
+		if upper := strings.ToUpper(w); m.isInitialism(upper) {
+			buf.WriteString(upper)
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(w)
+		buf.WriteRune(unicode.ToUpper(r))
+		buf.WriteString(w[n:])
+	}
+	return buf.String()
+}
+
+// goIdent converts s to a valid Go identifier, keeping its case. Each run of
+// characters that cannot appear in an identifier is replaced with an
+// underscore, except at the start or end where it is removed. An underscore
+// is prefixed if the result starts with a digit and suffixed if it is a
+// keyword. Returns an error if s has no letters, digits, or underscores.
+func goIdent(s string) (string, error) {
+	var buf strings.Builder
+	invalid := false
+	for _, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			invalid = true
+			continue
+		} else if invalid && buf.Len() > 0 {
+			buf.WriteByte('_')
+		}
+		buf.WriteRune(r)
+		invalid = false
+	}
+
+	ident := buf.String()
+	if ident == "" {
+		return "", fmt.Errorf("goIdent: no identifier characters in %q", s)
+	} else if r, _ := utf8.DecodeRuneInString(ident); unicode.IsDigit(r) {
+		ident = "_" + ident
+	} else if token.IsKeyword(ident) {
+		ident += "_"
+	}
+	return ident, nil
+}
+
+// goTag returns a Go struct tag, including backticks, from key/value pairs,
+// e.g. `json:"name" yaml:"name"`. Keys with empty values are omitted and an
+// empty string is returned if no keys remain. If the final argument is
+// "omitempty" then ",omitempty" is appended to every value except "-".
+func goTag(pairs ...string) (string, error) {
+	var omitempty bool
+	if len(pairs)%2 == 1 && pairs[len(pairs)-1] == "omitempty" {
+		omitempty, pairs = true, pairs[:len(pairs)-1]
+	}
+	if len(pairs)%2 == 1 {
+		return "", fmt.Errorf("goTag: odd number of arguments: %d", len(pairs))
+	}
+
+	var tags []string
+	for i := 0; i < len(pairs); i += 2 {
+		key, value := pairs[i], pairs[i+1]
+		if key == "" || strings.IndexFunc(key, func(r rune) bool {
+			return r <= ' ' || r == ':' || r == '"' || r == '`' || r == 0x7f
+		}) != -1 {
+			return "", fmt.Errorf("goTag: invalid key: %q", key)
+		} else if value == "" {
+			continue
+		} else if omitempty && value != "-" {
+			value += ",omitempty"
+		}
+
+		// Values may not contain backticks as tags are raw string literals.
+		quoted := strconv.Quote(value)
+		if strings.Contains(quoted, "`") {
+			return "", fmt.Errorf("goTag: value for %q cannot contain a backtick", key)
+		}
+		tags = append(tags, key+":"+quoted)
+	}
+	if len(tags) == 0 {
+		return "", nil
+	}
+	return "`" + strings.Join(tags, " ") + "`", nil
+}
+
+// goEnum returns a Go const block declaring each of names in order with
+// iota. The first constant has the type typ, which the others share, unless
+// typ is empty. Names must be valid Go identifiers.
+func goEnum(typ string, names interface{}) (string, error) {
+	items := toList(names)
+	if len(items) == 0 {
+		return "", errors.New("goEnum: no names")
+	} else if typ != "" && !token.IsIdentifier(typ) {
+		return "", fmt.Errorf("goEnum: invalid type name: %q", typ)
+	}
+
+	var buf strings.Builder
+	buf.WriteString("const (\n")
+	for i, item := range items {
+		name, ok := item.(string)
+		if !ok || !token.IsIdentifier(name) {
+			return "", fmt.Errorf("goEnum: invalid name: %v", item)
+		}
+
+		buf.WriteString("\t" + name)
+		if i == 0 && typ != "" {
+			buf.WriteString(" " + typ + " = iota")
+		} else if i == 0 {
+			buf.WriteString(" = iota")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString(")")
+	return buf.String(), nil
+}
+
+// appendWord appends word to words if it is not empty.
+func appendWord(words []string, word []rune) []string {
+	if len(word) == 0 {
+		return words
+	}
+	return append(words, string(word))
+}
+
+// isInitialism returns true if s is in GoInitialisms or Initialisms.
+func (m *Main) isInitialism(s string) bool {
+	for _, a := range [][]string{GoInitialisms, m.Initialisms} {
+		for _, v := range a {
+			if strings.ToUpper(v) == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasItem returns true if the slice xs contains v. Numeric values of
+// different types are equal if they have the same value.
+func hasItem(xs, v interface{}) bool {
+	for _, x := range toList(xs) {
+		if equalValue(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// in returns true if v is contained in the slice xs.
+func in(v, xs interface{}) bool {
+	return hasItem(xs, v)
+}
+
+// equalValue returns true if a and b are deeply equal or are numerically equal.
+func equalValue(a, b interface{}) bool {
+	if x, ok := toFloat64(a); ok {
+		if y, ok := toFloat64(b); ok {
+			return x == y
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// numCompareFunc returns a function that compares two numeric values of any
+// numeric type after converting both to float64.
+func numCompareFunc(fn func(a, b float64) bool) func(a, b interface{}) (bool, error) {
+	return func(a, b interface{}) (bool, error) {
+		x, ok := toFloat64(a)
+		if !ok {
+			return false, fmt.Errorf("non-numeric value: %#v", a)
+		}
+		y, ok := toFloat64(b)
+		if !ok {
+			return false, fmt.Errorf("non-numeric value: %#v", b)
+		}
+		return fn(x, y), nil
+	}
+}
+
+// errDivideByZero is returned by numDiv & numMod for a zero divisor.
+var errDivideByZero = errors.New("division by zero")
+
+// arithFunc returns the arithmetic function named name, which applies its
+// operation to each operand in turn. If every operand is a Go integer, the
+// result is an int64 and division truncates. If any operand is a float, such
+// as a JSON number, the result is a float64 unless it is a whole number that
+// fits in an int64, in which case it is an int64.
+func arithFunc(name string) func(v interface{}, rest ...interface{}) (interface{}, error) {
+	return func(v interface{}, rest ...interface{}) (interface{}, error) {
+		operands := append([]interface{}{v}, rest...)
+		ints, isInt := make([]int64, len(operands)), true
+		for i, operand := range operands {
+			if ints[i], isInt = toInt64(operand); !isInt {
+				break
+			}
+		}
+
+		if isInt {
+			x := ints[0]
+			for _, y := range ints[1:] {
+				var err error
+				if x, err = intOp(name, x, y); err != nil {
+					return nil, fmt.Errorf("%s: %s", name, err)
+				}
+			}
+			return x, nil
+		}
+
+		var x float64
+		for i, operand := range operands {
+			y, ok := toFloat64(operand)
+			if !ok {
+				return nil, fmt.Errorf("%s: non-numeric value: %#v", name, operand)
+			} else if i == 0 {
+				x = y
+				continue
+			}
+
+			var err error
+			if x, err = floatOp(name, x, y); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		if x == math.Trunc(x) && x >= math.MinInt64 && x < math.MaxInt64 {
+			return int64(x), nil
+		}
+		return x, nil
+	}
+}
+
+// binaryArithFunc restricts the arithmetic function fn to two operands.
+func binaryArithFunc(fn func(v interface{}, rest ...interface{}) (interface{}, error)) func(a, b interface{}) (interface{}, error) {
+	return func(a, b interface{}) (interface{}, error) { return fn(a, b) }
+}
+
+// intOp applies the arithmetic operation name to the integers a & b.
+func intOp(name string, a, b int64) (int64, error) {
+	switch name {
+	case "numAdd":
+		return a + b, nil
+	case "numSub":
+		return a - b, nil
+	case "numMul":
+		return a * b, nil
+	}
+
+	if b == 0 {
+		return 0, errDivideByZero
+	} else if name == "numDiv" {
+		return a / b, nil
+	}
+	return a % b, nil
+}
+
+// floatOp applies the arithmetic operation name to the floats a & b.
+func floatOp(name string, a, b float64) (float64, error) {
+	switch name {
+	case "numAdd":
+		return a + b, nil
+	case "numSub":
+		return a - b, nil
+	case "numMul":
+		return a * b, nil
+	}
+
+	if b == 0 {
+		return 0, errDivideByZero
+	} else if name == "numDiv" {
+		return a / b, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+// toInt64 converts a value of a Go integer type to an int64. Returns false
+// if v is not an integer or is a uint too large for an int64.
+func toInt64(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint()), rv.Uint() <= math.MaxInt64
+	default:
+		return 0, false
+	}
+}
+
+// toYAML encodes v as YAML. The trailing newline is removed so the result
+// can be embedded with indent or nindent.
+func toYAML(v interface{}) (string, error) {
+	buf, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(buf), "\n"), nil
+}
+
+// fromYAML decodes a YAML document. Values are decoded into the same types
+// as JSON data so the result can be used interchangeably.
+func fromYAML(s string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// isSet returns true if v is a map containing key, even if its value is nil,
+// or a struct with the named field. Unlike an index or field lookup, this
+// distinguishes an absent key from a null value.
+func isSet(v interface{}, key string) bool {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		return rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid()
+	case reflect.Struct:
+		return rv.FieldByName(key).IsValid()
+	}
+	return false
+}
+
+// fieldValue returns the value of key in a map or the named field of a struct.
+// Returns nil if the value cannot be found.
+func fieldValue(v interface{}, key string) interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		if e := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())); e.IsValid() {
+			return e.Interface()
+		}
+	case reflect.Struct:
+		if f := rv.FieldByName(key); f.IsValid() && f.CanInterface() {
+			return f.Interface()
+		}
+	}
+	return nil
+}
+
+// jsonPointer returns the value within v referenced by the RFC 6901 JSON
+// Pointer ptr. Returns an error if the referenced value does not exist.
+func jsonPointer(v interface{}, ptr string) (interface{}, error) {
+	if ptr == "" {
+		return v, nil
+	} else if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid json pointer: %q", ptr)
+	}
+
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.Replace(strings.Replace(tok, "~1", "/", -1), "~0", "~", -1)
+
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		switch rv.Kind() {
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("json pointer %q: non-string map key type", ptr)
+			}
+			e := rv.MapIndex(reflect.ValueOf(tok).Convert(rv.Type().Key()))
+			if !e.IsValid() {
+				return nil, fmt.Errorf("json pointer %q: key not found: %q", ptr, tok)
+			}
+			v = e.Interface()
+		case reflect.Slice, reflect.Array:
+			// Indices must not have leading zeros, per the RFC.
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || (len(tok) > 1 && tok[0] == '0') {
+				return nil, fmt.Errorf("json pointer %q: invalid array index: %q", ptr, tok)
+			} else if i >= rv.Len() {
+				return nil, fmt.Errorf("json pointer %q: array index out of range: %d", ptr, i)
+			}
+			v = rv.Index(i).Interface()
+		case reflect.Struct:
+			f := rv.FieldByName(tok)
+			if !f.IsValid() || !f.CanInterface() {
+				return nil, fmt.Errorf("json pointer %q: field not found: %q", ptr, tok)
+			}
+			v = f.Interface()
+		default:
+			return nil, fmt.Errorf("json pointer %q: cannot index %T with %q", ptr, v, tok)
+		}
+	}
+	return v, nil
+}
+
+// lessValue returns true if a sorts before b. Nil values sort first.
+func lessValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+
+	if x, ok := toFloat64(a); ok {
+		if y, ok := toFloat64(b); ok {
+			return x < y
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// formatIntFunc returns a function, named name, that formats an integer in
+// the given base without a prefix, e.g. "-ff" for hex -255.
+func formatIntFunc(name string, base int) func(v interface{}) (string, error) {
+	return func(v interface{}) (string, error) {
+		n, err := toBigInt(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", name, err)
+		}
+		return n.Text(base), nil
+	}
+}
+
+// goHexLiteral returns the integer v as a Go hexadecimal literal, e.g. 0xff.
+// Negative values are written as a negated literal, e.g. -0xff.
+func goHexLiteral(v interface{}) (string, error) {
+	n, err := toBigInt(v)
+	if err != nil {
+		return "", fmt.Errorf("goHexLiteral: %s", err)
+	} else if n.Sign() < 0 {
+		return "-0x" + new(big.Int).Neg(n).Text(16), nil
+	}
+	return "0x" + n.Text(16), nil
+}
+
+// parseDuration parses a duration such as "1h30m" and returns it in
+// nanoseconds.
+func parseDuration(s string) (int64, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parseDuration: %s", err)
+	}
+	return int64(d), nil
+}
+
+// formatDuration formats the integer number of nanoseconds v as a duration
+// such as "1h30m0s".
+func formatDuration(v interface{}) (string, error) {
+	n, err := toBigInt(v)
+	if err != nil {
+		return "", fmt.Errorf("formatDuration: %s", err)
+	} else if !n.IsInt64() {
+		return "", fmt.Errorf("formatDuration: out of range: %s", n)
+	}
+	return time.Duration(n.Int64()).String(), nil
+}
+
+// byteUnits are the units of sizes accepted by parseBytes, keyed by their
+// lowercase names. IEC units are multiples of 1024 and SI units of 1000.
+var byteUnits = map[string]int64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"eb":  1e18,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+	"eib": 1 << 60,
+}
+
+// byteSizeRegex matches a size such as "1.5 MiB", capturing the number and
+// the optional unit.
+var byteSizeRegex = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)\s*$`)
+
+// parseBytes parses a size such as "1 MiB", "1.5GB", or "512" and returns
+// it in bytes, rounded to the nearest byte so that the rounded sizes written
+// by formatBytes can be parsed.
+func parseBytes(s string) (int64, error) {
+	a := byteSizeRegex.FindStringSubmatch(s)
+	if a == nil {
+		return 0, fmt.Errorf("parseBytes: invalid size: %q", s)
+	}
+
+	unit := byteUnits["b"]
+	if a[2] != "" {
+		var ok bool
+		if unit, ok = byteUnits[strings.ToLower(a[2])]; !ok {
+			return 0, fmt.Errorf("parseBytes: unknown unit in %q", s)
+		}
+	}
+
+	// Parse exactly so that decimal sizes are only rounded once, with halves
+	// rounded up.
+	r, ok := new(big.Rat).SetString(a[1])
+	if !ok {
+		return 0, fmt.Errorf("parseBytes: invalid size: %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(unit))
+	n := new(big.Int).Mul(r.Num(), big.NewInt(2))
+	n.Add(n, r.Denom())
+	n.Quo(n, new(big.Int).Mul(r.Denom(), big.NewInt(2)))
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("parseBytes: out of range: %q", s)
+	}
+	return n.Int64(), nil
+}
+
+// formatBytes formats the integer number of bytes v using the largest IEC
+// unit that it is at least one of, with up to two decimal places, e.g.
+// "1 MiB" or "1.5 KiB".
+func formatBytes(v interface{}) (string, error) {
+	n, err := toBigInt(v)
+	if err != nil {
+		return "", fmt.Errorf("formatBytes: %s", err)
+	} else if !n.IsInt64() {
+		return "", fmt.Errorf("formatBytes: out of range: %s", n)
+	}
+
+	// Use the next unit if rounding reaches it, e.g. for 1023.999 KiB.
+	size := float64(n.Int64())
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	i := 0
+	for ; i < len(units)-1 && math.Abs(math.Round(size*100)/100) >= 1024; i++ {
+		size /= 1024
+	}
+	return strconv.FormatFloat(math.Round(size*100)/100, 'f', -1, 64) + " " + units[i], nil
+}
+
+// toBigInt converts an integer to a big.Int. Floats must be whole numbers,
+// and strings are parsed as Go integer literals so that values too large for
+// a float64, such as in JSON data, can be passed exactly.
+func toBigInt(v interface{}) (*big.Int, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsInf(f, 0) || f != math.Trunc(f) {
+			return nil, fmt.Errorf("not an integer: %v", v)
+		}
+		n, _ := big.NewFloat(f).Int(nil)
+		return n, nil
+	case reflect.String:
+		if n, ok := new(big.Int).SetString(rv.String(), 0); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("not an integer: %q", v)
+	default:
+		return nil, fmt.Errorf("expected integer, got %T", v)
+	}
+}
+
+// toFloat64 converts a numeric value to a float64.
+// Returns false if v is not a numeric type.
+func toFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// clampRange restricts i & j to the range [0,n] such that i <= j.
+func clampRange(i, j, n int) (int, int) {
+	if i < 0 {
+		i = 0
+	} else if i > n {
+		i = n
+	}
+	if j > n {
+		j = n
+	}
+	if j < i {
+		j = i
+	}
+	return i, j
+}
+
+// toList converts a slice or array to a list of interfaces.
+// Returns nil if v is not a slice or array.
+func toList(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+	default:
+		return nil
+	}
+
+	a := make([]interface{}, rv.Len())
+	for i := range a {
+		a[i] = rv.Index(i).Interface()
+	}
+	return a
+}
@@ -0,0 +1,1278 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/constant"
+	"go/format"
+	"go/token"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+// Ensure list functions return elements and handle empty slices, and that
+// sprig's list functions and the builtin slice are not replaced.
+func TestFuncs_List(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		output string
+	}{
+		{source: `{{first .}}`, data: []interface{}{"a", "b", "c"}, output: `a`},
+		{source: `{{last .}}`, data: []interface{}{"a", "b", "c"}, output: `c`},
+		{source: `{{rest .}}`, data: []interface{}{"a", "b", "c"}, output: `[b c]`},
+		{source: `{{sublist . 1 2}}`, data: []interface{}{"a", "b", "c"}, output: `[b]`},
+		{source: `{{first .}}`, data: []interface{}{}, output: `<no value>`},
+		{source: `{{last .}}`, data: []interface{}{}, output: `<no value>`},
+		{source: `{{rest .}}`, data: []interface{}{}, output: `[]`},
+		{source: `{{sublist . 0 1}}`, data: []interface{}{}, output: `[]`},
+		{source: `{{slice . 1}}`, data: "abc", output: `bc`},
+		{source: `{{slice . 1 2}}`, data: "abc", output: `b`},
+		{source: `{{slice . 0 1 2}}`, data: []string{"a", "b", "c"}, output: `[a]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, tt.data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure sublist clamps out-of-bounds indices instead of failing.
+func TestFuncs_Sublist_OutOfBounds(t *testing.T) {
+	data := []interface{}{"a", "b", "c"}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{source: `{{sublist . 1 10}}`, output: `[b c]`},
+		{source: `{{sublist . -5 1}}`, output: `[a]`},
+		{source: `{{sublist . 5 10}}`, output: `[]`},
+		{source: `{{sublist . 2 1}}`, output: `[]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure sortBy orders maps by a key and keeps equal elements in input order.
+func TestFuncs_SortBy(t *testing.T) {
+	data := []interface{}{
+		map[string]interface{}{"name": "c", "n": float64(2)},
+		map[string]interface{}{"name": "a", "n": float64(10)},
+		map[string]interface{}{"name": "b", "n": float64(2)},
+		map[string]interface{}{"name": "d"},
+	}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{source: `{{range sortBy "name" .}}{{.name}}{{end}}`, output: `abcd`},
+		{source: `{{range sortBy "n" .}}{{.name}}{{end}}`, output: `dcba`},
+		{source: `{{sortBy "name" "x"}}`, output: `[]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure sortAlpha orders values by their string representation.
+func TestFuncs_SortAlpha(t *testing.T) {
+	if output, err := NewMain().RunTemplate(`{{sortAlpha .}}`, []interface{}{"pear", "apple", "fig"}); err != nil {
+		t.Fatal(err)
+	} else if output != `[apple fig pear]` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure keys and values return map contents in sorted key order.
+func TestFuncs_KeysValues(t *testing.T) {
+	data := map[string]interface{}{"b": 2, "c": 3, "a": 1}
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		output string
+	}{
+		{source: `{{keys .}}`, data: data, output: `[a b c]`},
+		{source: `{{values .}}`, data: data, output: `[1 2 3]`},
+		{source: `{{keys .}}`, data: map[int]string{10: "x", 2: "y"}, output: `[2 10]`},
+		{source: `{{keys .}}`, data: []interface{}{"a"}, output: `[]`},
+		{source: `{{keys .x .y}}`, data: map[string]interface{}{"x": data, "y": map[string]int{"d": 4, "B": 0}}, output: `[B a b c d]`},
+		{source: `{{keys . | sortAlpha | join ","}}`, data: data, output: `a,b,c`},
+		{source: `{{values .}}`, data: "a", output: `[]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, tt.data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure a defined template can be executed by a name computed at runtime.
+func TestFuncs_Tpl(t *testing.T) {
+	source := `{{define "a"}}A{{.}}{{end}}{{define "b"}}B{{.}}{{end}}` +
+		`{{range .}}{{tpl .kind .value}}{{end}}`
+	data := []interface{}{
+		map[string]interface{}{"kind": "b", "value": 1},
+		map[string]interface{}{"kind": "a", "value": 2},
+	}
+	if output, err := NewMain().RunTemplate(source, data); err != nil {
+		t.Fatal(err)
+	} else if output != `B1A2` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure executing an undefined template returns an error.
+func TestFuncs_Tpl_ErrUndefined(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{tpl "x" .}}`, nil); err == nil || !strings.Contains(err.Error(), `no template "x"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure strings can be split into lines and joined back together.
+func TestFuncs_Lines(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{range lines .}}// {{.}}` + "\n" + `{{end}}`, "// a\n// \n// b\n"},
+		{`{{len (lines "a\nb")}}`, `2`},
+		{`{{len (lines "a\nb\n\n")}}`, `3`},
+		{`{{len (lines "")}}`, `0`},
+		{`{{lines . | join ", "}}`, `a, , b`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, "a\n\nb\n"); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+	}
+}
+
+// Ensure lines can be taken from the start & end of a string.
+func TestFuncs_HeadTail(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{head 2 .}}`, "a\nb\n"},
+		{`{{head 0 .}}`, ""},
+		{`{{head 5 .}}`, "a\nb\nc\n"},
+		{`{{head 1 "a"}}`, "a"},
+		{`{{tail 2 .}}`, "b\nc\n"},
+		{`{{tail 0 .}}`, ""},
+		{`{{tail 5 .}}`, "a\nb\nc\n"},
+		{`{{tail 1 "a\nb"}}`, "b"},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, "a\nb\nc\n"); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+	}
+}
+
+// Ensure environment variables can be read with an optional default.
+func TestFuncs_Env(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{env "HOST"}}`, `example.com`},
+		{`{{env "HOST" "localhost"}}`, `example.com`},
+		{`{{env "PORT"}}`, ``},
+		{`{{env "PORT" "8080"}}`, `8080`},
+	} {
+		m := NewMain()
+		m.OS.GetenvFn = func(key string) string {
+			if key == "HOST" {
+				return "example.com"
+			}
+			return ""
+		}
+		if output, err := m.RunTemplate(tt.source, nil); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure names are converted to Go identifiers using Go's initialisms.
+func TestFuncs_GoName(t *testing.T) {
+	for _, tt := range []struct {
+		input  string
+		output string
+	}{
+		{"user_id", "UserID"},
+		{"api_url", "APIURL"},
+		{"userId", "UserID"},
+		{"HTTPServer", "HTTPServer"},
+		{"http_server", "HTTPServer"},
+		{"first-name", "FirstName"},
+		{"created at", "CreatedAt"},
+		{"uuid", "UUID"},
+		{"utf8_string", "UTF8String"},
+		{"ids", "Ids"},
+		{"idx", "Idx"},
+		{"__id__", "ID"},
+		{"v2_api", "V2API"},
+		{"k8s_config", "K8sConfig"},
+		{"", ""},
+	} {
+		if output, err := NewMain().RunTemplate(`{{goName .}}`, tt.input); err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		} else if output != tt.output {
+			t.Fatalf("%q: unexpected output: %s", tt.input, output)
+		}
+	}
+}
+
+// Ensure additional initialisms can be recognized by goName.
+func TestFuncs_GoName_Initialisms(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-initialism", "k8s", "-initialism", "GRPC"}); err != nil {
+		t.Fatal(err)
+	} else if output, err := m.RunTemplate(`{{goName "k8s_grpc_id"}}`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `K8SGRPCID` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure values can be concatenated with and without spaces.
+func TestFuncs_CatConcat(t *testing.T) {
+	data := map[string]interface{}{"name": "user", "n": float64(2), "ok": true}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{cat "a" "b" "c"}}`, `a b c`},
+		{`{{cat .name .n .ok}}`, `user 2 true`},
+		{`{{cat}}`, ``},
+		{`{{concat "a" "b" "c"}}`, `abc`},
+		{`{{concat .name "_" .n ".go"}}`, `user_2.go`},
+		{`{{concat 1 2 .ok}}`, `12true`},
+		{`{{concat}}`, ``},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure paths can be joined and made relative.
+func TestFuncs_Paths(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{pathJoin "example.com/app" "internal/models"}}`, `example.com/app/internal/models`},
+		{`{{pathJoin "example.com/app/" "/models/"}}`, `example.com/app/models`},
+		{`{{pathJoin "example.com/app" "" "models"}}`, `example.com/app/models`},
+		{`{{pathJoin}}`, ``},
+		{`{{relPath "gen/models" "templates/model.go.tmpl"}}`, `../../templates/model.go.tmpl`},
+		{`{{relPath "/src/app/" "/src/app/internal/"}}`, `internal`},
+		{`{{relPath "/src/app" "/src/app/"}}`, `.`},
+		{`{{relPath "a" "a"}}`, `.`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, nil); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure a relative path cannot be computed between absolute and relative paths.
+func TestFuncs_RelPath_ErrMixed(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{relPath "/src/app" "models"}}`, nil); err == nil || !strings.Contains(err.Error(), `Rel: can't make models relative to /src/app`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure comments can be stripped from source in each style.
+func TestFuncs_StripComments(t *testing.T) {
+	for _, tt := range []struct {
+		style  string
+		input  string
+		output string
+	}{
+		{"go", "// Package a.\npackage a\n\n/*\n * Doc.\n */\nvar x = 1 // one\n", "package a\n\nvar x = 1\n"},
+		{"go", "var s = \"http://x\" /* url */ + `/* raw */`\n", "var s = \"http://x\"  + `/* raw */`\n"},
+		{"go", "var r = '\"' // quote\nvar s = \"\\\" // \"\n", "var r = '\"'\nvar s = \"\\\" // \"\n"},
+		{"c", "int x; /* a\nb */ int y; // z\nchar c = '/';\n", "int x;\n int y;\nchar c = '/';\n"},
+		{"go", "var a = 1 /*\n*/ var b = 2", "var a = 1\n var b = 2"},
+		{"shell", "#!/bin/sh\n# Setup.\necho 'a # b' \"c # d\" # e\necho ${#x} a#b\n", "#!/bin/sh\necho 'a # b' \"c # d\"\necho ${#x} a#b\n"},
+		{"shell", "", ""},
+	} {
+		data := map[string]interface{}{"style": tt.style, "input": tt.input}
+		if output, err := NewMain().RunTemplate(`{{.input | stripComments .style}}`, data); err != nil {
+			t.Fatalf("%s: %q: %s", tt.style, tt.input, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: %q: unexpected output: %q", tt.style, tt.input, output)
+		}
+	}
+}
+
+// Ensure an unknown comment style returns an error.
+func TestFuncs_StripComments_ErrUnknownStyle(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{"x" | stripComments "lisp"}}`, nil); err == nil || !strings.Contains(err.Error(), `stripComments: unknown style "lisp", expected go, c, or shell`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure strings are escaped for embedding in JSON & YAML strings.
+func TestFuncs_Escape(t *testing.T) {
+	for _, tt := range []struct {
+		fn     string
+		input  string
+		output string
+	}{
+		{"jsonEscape", "plain", "plain"},
+		{"jsonEscape", "say \"hi\": a\\b\n\tc", `say \"hi\": a\\b\n\tc`},
+		{"jsonEscape", "<a & b>\x00\u2028", `<a & b>\u0000\u2028`},
+		{"yamlEscape", "plain", "plain"},
+		{"yamlEscape", "key: \"v\" # c\r\n\\", `key: \"v\" # c\r\n\\`},
+		{"yamlEscape", "\x00\x7f\u0085\u2028\ufeffé", `\x00\x7F\N\L\uFEFFé`},
+	} {
+		data := map[string]interface{}{"input": tt.input}
+		if output, err := NewMain().RunTemplate(`{{`+tt.fn+` .input}}`, data); err != nil {
+			t.Fatalf("%s: %q: %s", tt.fn, tt.input, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: %q: unexpected output: %q", tt.fn, tt.input, output)
+		}
+	}
+}
+
+// Ensure escaped strings round-trip through JSON & YAML parsers.
+func TestFuncs_Escape_RoundTrip(t *testing.T) {
+	input := "a: \"b\"\n\t# c \\ d\x01\u2028"
+	data := map[string]interface{}{"input": input}
+	output, err := NewMain().RunTemplate(`{"j": "{{jsonEscape .input}}", "y": {{printf "%q" (printf "x: \"%s\"" (yamlEscape .input))}}}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v struct{ J, Y string }
+	if err := json.Unmarshal([]byte(output), &v); err != nil {
+		t.Fatal(err)
+	} else if v.J != input {
+		t.Fatalf("unexpected json value: %q", v.J)
+	}
+
+	var y map[string]string
+	if err := yaml.Unmarshal([]byte(v.Y), &y); err != nil {
+		t.Fatal(err)
+	} else if y["x"] != input {
+		t.Fatalf("unexpected yaml value: %q", y["x"])
+	}
+}
+
+// Ensure text is reflowed by paragraph to a maximum width.
+func TestFuncs_Reflow(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		input  string
+		output string
+	}{
+		{`{{reflow 20 .}}`, "The quick brown fox jumps over\nthe lazy dog.\n\n\nA second paragraph.\n", "The quick brown fox\njumps over the lazy\ndog.\n\n\nA second paragraph.\n"},
+		{`{{reflow 10 .}}`, "see https://example.com/a/long/path now", "see\nhttps://example.com/a/long/path\nnow"},
+		{`{{reflow 10 .}}`, "  ", ""},
+		{`{{wrap 5 .}}`, "ab cd ef", "ab cd\nef"},
+		{`{{reflowComment 24 .}}`, "Package foo does the thing that foo does.\n\nSee the README.", "// Package foo does the\n// thing that foo does.\n//\n// See the README."},
+		{`{{reflowComment 20 .}}`, "\t// Foo is a very long\n\t// comment indeed.\n\t//\n\t// Bar.\n", "\t// Foo is a very\n\t// long comment\n\t// indeed.\n\t//\n\t// Bar.\n"},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, tt.input); err != nil {
+			t.Fatalf("%s: %q: %s", tt.source, tt.input, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: %q: unexpected output: %q", tt.source, tt.input, output)
+		}
+	}
+}
+
+// Ensure reflowing to a width with no room for text returns an error.
+func TestFuncs_Reflow_ErrWidth(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{reflow 0 "x"}}`, nil); err == nil || !strings.Contains(err.Error(), `reflow: width must be positive, got 0`) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := NewMain().RunTemplate(`{{reflowComment 3 "x"}}`, nil); err == nil || !strings.Contains(err.Error(), `reflowComment: width 3 is too small for the comment prefix`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure arbitrary keys are converted to valid, non-keyword Go identifiers.
+func TestFuncs_GoIdent(t *testing.T) {
+	for _, tt := range []struct {
+		input  string
+		output string
+	}{
+		{"userID", "userID"},
+		{"max retry count", "max_retry_count"},
+		{"content-type", "content_type"},
+		{" -- a . b -- ", "a_b"},
+		{"_private", "_private"},
+		{"2fa", "_2fa"},
+		{"3-d", "_3_d"},
+		{"type", "type_"},
+		{"func", "func_"},
+		{"string", "string"},
+		{"café", "café"},
+		{"日本", "日本"},
+	} {
+		if output, err := NewMain().RunTemplate(`{{goIdent .}}`, tt.input); err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		} else if output != tt.output {
+			t.Fatalf("%q: unexpected output: %q", tt.input, output)
+		} else if !token.IsIdentifier(output) {
+			t.Fatalf("%q: invalid identifier: %q", tt.input, output)
+		}
+	}
+
+	if _, err := NewMain().RunTemplate(`{{goIdent .}}`, "--"); err == nil || !strings.Contains(err.Error(), `goIdent: no identifier characters in "--"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure arithmetic works across Go integers and JSON numbers.
+func TestFuncs_Arith(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"n": 7, "half": 0.5, "big": 1000000, "list": ["a", "b", "c"]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{numAdd 1 2 3}}`, `6`},
+		{`{{numAdd .n 1}}`, `8`},
+		{`{{numAdd .n .half}}`, `7.5`},
+		{`{{numSub 2 .n}}`, `-5`},
+		{`{{numMul .big 1000}}`, `1000000000`},
+		{`{{numMul .half 3}}`, `1.5`},
+		{`{{numDiv 7 2}}`, `3`},
+		{`{{numDiv .n 2}}`, `3.5`},
+		{`{{numDiv .big 4}}`, `250000`},
+		{`{{numMod 7 3}}`, `1`},
+		{`{{numMod .n 2.5}}`, `2`},
+		{`{{index .list (numSub .n 5)}}`, `c`},
+		{`{{printf "%T" (numAdd .n 1)}} {{printf "%T" (numDiv .n 2)}}`, `int64 float64`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+	}
+}
+
+// Ensure sprig's integer arithmetic functions are not replaced.
+func TestFuncs_Arith_Sprig(t *testing.T) {
+	if output, err := NewMain().RunTemplate(`{{add 1 2.5}} {{div 7 2}}`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `3 3` {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure arithmetic returns errors for division by zero & non-numeric values.
+func TestFuncs_Arith_Err(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		err    string
+	}{
+		{`{{numDiv 1 0}}`, `numDiv: division by zero`},
+		{`{{numDiv 1.5 0}}`, `numDiv: division by zero`},
+		{`{{numMod 1 0}}`, `numMod: division by zero`},
+		{`{{numAdd 1 "2"}}`, `numAdd: non-numeric value: "2"`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+	}
+}
+
+// Ensure names can be declared as a gofmt-clean const block with iota.
+func TestFuncs_GoEnum(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{goEnum "Color" (list "Red" "Green" "Blue")}}`, "const (\n\tRed Color = iota\n\tGreen\n\tBlue\n)"},
+		{`{{goEnum "" (list "A" "B")}}`, "const (\n\tA = iota\n\tB\n)"},
+		{`{{goEnum "Kind" .}}`, "const (\n\tKindFoo Kind = iota\n)"},
+	} {
+		output, err := NewMain().RunTemplate(tt.source, []interface{}{"KindFoo"})
+		if err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+
+		if formatted, err := format.Source([]byte(output)); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if string(formatted) != output {
+			t.Fatalf("%s: output not gofmt-clean: %q", tt.source, formatted)
+		}
+	}
+}
+
+// Ensure invalid enum names return an error.
+func TestFuncs_GoEnum_Err(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		err    string
+	}{
+		{`{{goEnum "Color" (list)}}`, `goEnum: no names`},
+		{`{{goEnum "Color" (list "Red" "dark-blue")}}`, `goEnum: invalid name: dark-blue`},
+		{`{{goEnum "Color" (list "Red" 2)}}`, `goEnum: invalid name: 2`},
+		{`{{goEnum "[]int" (list "Red")}}`, `goEnum: invalid type name: "[]int"`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+	}
+}
+
+// Ensure integers are formatted in other bases as valid Go literals.
+func TestFuncs_FormatInt(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		output string
+		prefix string
+		value  string
+	}{
+		{`{{goHexLiteral .}}`, float64(255), "0xff", "", "255"},
+		{`{{goHexLiteral .}}`, -255, "-0xff", "", "-255"},
+		{`{{goHexLiteral .}}`, uint64(18446744073709551615), "0xffffffffffffffff", "", "18446744073709551615"},
+		{`{{goHexLiteral .}}`, "123456789012345678901234567890", "0x18ee90ff6c373e0ee4e3f0ad2", "", "123456789012345678901234567890"},
+		{`{{hex .}}`, 4096, "1000", "0x", "4096"},
+		{`{{oct .}}`, 493, "755", "0o", "493"},
+		{`{{bin .}}`, int8(-5), "-101", "0b", "-5"},
+		{`{{bin .}}`, 0, "0", "0b", "0"},
+	} {
+		output, err := NewMain().RunTemplate(tt.source, tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v: %s", tt.source, tt.data, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: %v: unexpected output: %q", tt.source, tt.data, output)
+		}
+
+		// Ensure the output, with its base prefix, is a Go literal of the value.
+		lit := tt.prefix + strings.TrimPrefix(output, "-")
+		v := constant.MakeFromLiteral(lit, token.INT, 0)
+		if v.Kind() != constant.Int {
+			t.Fatalf("%s: %v: invalid Go literal: %s", tt.source, tt.data, lit)
+		} else if strings.HasPrefix(output, "-") {
+			v = constant.UnaryOp(token.SUB, v, 0)
+		}
+		if v.ExactString() != tt.value {
+			t.Fatalf("%s: %v: unexpected value: %s", tt.source, tt.data, v)
+		}
+	}
+}
+
+// Ensure durations & byte sizes can be parsed and formatted.
+func TestFuncs_Units(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		output string
+	}{
+		{`{{parseDuration "1h30m"}}`, nil, "5400000000000"},
+		{`{{parseDuration "-1.5s"}}`, nil, "-1500000000"},
+		{`{{formatDuration .}}`, float64(5400000000000), "1h30m0s"},
+		{`{{formatDuration (parseDuration "250ms")}}`, nil, "250ms"},
+		{`{{parseBytes "1 MiB"}}`, nil, "1048576"},
+		{`{{parseBytes "1.5kib"}}`, nil, "1536"},
+		{`{{parseBytes "0.1 KB"}}`, nil, "100"},
+		{`{{parseBytes "7 EiB" | printf "%T"}} {{parseBytes "512"}}`, nil, "int64 512"},
+		{`{{parseBytes "1.5 B"}} {{parseBytes "0.0004 KB"}}`, nil, "2 0"},
+		{`{{formatBytes 1500}} {{formatBytes 1500 | parseBytes}}`, nil, "1.46 KiB 1495"},
+		{`{{formatBytes .}}`, float64(1048576), "1 MiB"},
+		{`{{formatBytes 1536}}`, nil, "1.5 KiB"},
+		{`{{formatBytes 1000}}`, nil, "1000 B"},
+		{`{{formatBytes 1048575}}`, nil, "1 MiB"},
+		{`{{formatBytes -2048}}`, nil, "-2 KiB"},
+		{`{{formatBytes 0}}`, nil, "0 B"},
+		{`{{parseBytes (formatBytes 3221225472)}}`, nil, "3221225472"},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, tt.data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+	}
+}
+
+// Ensure invalid durations & byte sizes return errors.
+func TestFuncs_Units_Err(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		err    string
+	}{
+		{`{{parseDuration "90 minutes"}}`, `parseDuration: time: unknown unit`},
+		{`{{formatDuration 1.5}}`, `formatDuration: not an integer: 1.5`},
+		{`{{parseBytes "lots"}}`, `parseBytes: invalid size: "lots"`},
+		{`{{parseBytes "1 parsec"}}`, `parseBytes: unknown unit in "1 parsec"`},
+		{`{{parseBytes "16 EiB"}}`, `parseBytes: out of range: "16 EiB"`},
+		{`{{formatBytes "x"}}`, `formatBytes: not an integer: "x"`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+	}
+}
+
+// Ensure formatting a non-integer returns an error.
+func TestFuncs_FormatInt_Err(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		err    string
+	}{
+		{`{{hex .}}`, 1.5, `hex: not an integer: 1.5`},
+		{`{{goHexLiteral .}}`, "ten", `goHexLiteral: not an integer: "ten"`},
+		{`{{bin .}}`, nil, `bin: expected integer, got <nil>`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, tt.data); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: %v: unexpected error: %v", tt.source, tt.data, err)
+		}
+	}
+}
+
+// Ensure data can be written as Go literals with inferred types.
+func TestFuncs_GoLiteral(t *testing.T) {
+	for _, tt := range []struct {
+		data   string
+		output string
+	}{
+		{`"a \"b\"\n"`, `"a \"b\"\n"`},
+		{`true`, `true`},
+		{`null`, `nil`},
+		{`12`, `12`},
+		{`-1.5`, `-1.5`},
+		{`1e300`, `1e+300`},
+		{`[]`, `[]interface{}{}`},
+		{`{}`, `map[string]interface{}{}`},
+		{`[1, 2, 3]`, "[]int{\n\t1,\n\t2,\n\t3,\n}"},
+		{`[1, 2.5]`, "[]float64{\n\t1,\n\t2.5,\n}"},
+		{`["a", 1]`, "[]interface{}{\n\t\"a\",\n\t1,\n}"},
+		{`["a", null]`, "[]interface{}{\n\t\"a\",\n\tnil,\n}"},
+		{`{"b": "x", "a": "y"}`, "map[string]string{\n\t\"a\": \"y\",\n\t\"b\": \"x\",\n}"},
+		{`[[1], [2, 3]]`, "[][]int{\n\t{\n\t\t1,\n\t},\n\t{\n\t\t2,\n\t\t3,\n\t},\n}"},
+		{`[[1], ["a"]]`, "[]interface{}{\n\t[]int{\n\t\t1,\n\t},\n\t[]string{\n\t\t\"a\",\n\t},\n}"},
+		{`{"a": {"x": true}, "b": {}}`, "map[string]interface{}{\n\t\"a\": map[string]bool{\n\t\t\"x\": true,\n\t},\n\t\"b\": map[string]interface{}{},\n}"},
+		{`{"a": {"x": 1}, "b": {"y": 2}}`, "map[string]map[string]int{\n\t\"a\": {\n\t\t\"x\": 1,\n\t},\n\t\"b\": {\n\t\t\"y\": 2,\n\t},\n}"},
+	} {
+		var data interface{}
+		if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := NewMain().RunTemplate(`{{goLiteral .}}`, data); err != nil {
+			t.Fatalf("%s: %s", tt.data, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output:\n%s", tt.data, output)
+		}
+	}
+}
+
+// Ensure generated Go literals compile as declarations.
+func TestFuncs_GoLiteral_Format(t *testing.T) {
+	data := map[string]interface{}{"ports": []interface{}{float64(80), float64(443)}, "name": "web"}
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`package config; var Config = {{goLiteral .}}`), nil
+	}
+	var output []byte
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		output = data
+		return nil
+	}
+
+	m.Paths, m.Data, m.NoHeader = []string{"config.go.tmpl"}, data, true
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if string(output) != "package config\n\nvar Config = map[string]interface{}{\n\t\"name\": \"web\",\n\t\"ports\": []int{\n\t\t80,\n\t\t443,\n\t},\n}\n" {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure values that cannot be written as Go literals return an error.
+func TestFuncs_GoLiteral_ErrUnsupported(t *testing.T) {
+	data := map[string]interface{}{"f": func() {}}
+	if _, err := NewMain().RunTemplate(`{{goLiteral .}}`, data); err == nil || !strings.Contains(err.Error(), `goLiteral: unsupported type: func()`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure values can be formatted as strings to pass to other functions.
+func TestFuncs_Sprint(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{sprintf "%s_%03d" "id" 7 | upper}}`, `ID_007`},
+		{`{{printf "%s_%03d" "id" 7 | upper}}`, `ID_007`},
+		{`{{goName (sprintf "%s_%s" "user" "id")}}`, `UserID`},
+		{`{{sprint "a" 1 2 "b"}}`, `a1 2b`},
+		{`{{sprint}}`, ``},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, nil); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure the unparsed data file can be embedded verbatim.
+func TestFuncs_RawData(t *testing.T) {
+	const raw = "{\n  // comment\n  \"b\": 1.50,\n  \"a\": \"x\"\n}\n"
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(raw), nil
+	}
+	if err := m.ParseFlags([]string{"-data", "@data.jsonc"}); err != nil {
+		t.Fatal(err)
+	} else if output, err := m.RunTemplate("{{.b}}\n{{rawData}}", m.Data); err != nil {
+		t.Fatal(err)
+	} else if output != "1.5\n"+raw {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure rawData returns each JSONL record as it is rendered.
+func TestFuncs_RawData_JSONL(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-jsonl", "-template-string", "{{rawData}};"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Stdin.WriteString("{\"a\": 1}\n{\"b\":2}\n")
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `{"a": 1};{"b":2};` {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+// Ensure rawData returns an error if data was merged from multiple sources.
+func TestFuncs_RawData_ErrMerged(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data", `{"a":1}`, "-data", `{"b":2}`}); err != nil {
+		t.Fatal(err)
+	} else if _, err := m.RunTemplate(`{{rawData}}`, m.Data); err == nil || !strings.Contains(err.Error(), `rawData: data was not decoded from a single source`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure struct tags are built from key/value pairs.
+func TestFuncs_GoTag(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{goTag "json" "name"}}`, "`json:\"name\"`"},
+		{`{{goTag "json" "name,omitempty" "yaml" "name"}}`, "`json:\"name,omitempty\" yaml:\"name\"`"},
+		{`{{goTag "json" "name" "yaml" "name" "omitempty"}}`, "`json:\"name,omitempty\" yaml:\"name,omitempty\"`"},
+		{`{{goTag "json" "-" "db" "id" "omitempty"}}`, "`json:\"-\" db:\"id,omitempty\"`"},
+		{`{{goTag "json" "" "yaml" "name"}}`, "`yaml:\"name\"`"},
+		{`{{goTag "json" ""}}`, ""},
+		{`{{goTag "validate" "regexp=^\\d+\"$"}}`, "`validate:\"regexp=^\\\\d+\\\"$\"`"},
+		{`{{goTag "desc" "tab\there"}}`, "`desc:\"tab\\there\"`"},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, nil); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure invalid struct tag arguments return an error.
+func TestFuncs_GoTag_Err(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		err    string
+	}{
+		{`{{goTag "json"}}`, `goTag: odd number of arguments: 1`},
+		{`{{goTag "" "name"}}`, `goTag: invalid key: ""`},
+		{`{{goTag "a b" "name"}}`, `goTag: invalid key: "a b"`},
+		{`{{goTag "json:" "name"}}`, `goTag: invalid key: "json:"`},
+		{"{{goTag \"json\" \"a`b\"}}", `goTag: value for "json" cannot contain a backtick`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, nil); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+	}
+}
+
+// Ensure absent keys can be distinguished from null values.
+func TestFuncs_IsSet(t *testing.T) {
+	data := map[string]interface{}{"a": nil, "b": "x", "m": map[string]interface{}{"c": nil}}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{isSet . "a"}}`, `true`},
+		{`{{isSet . "b"}}`, `true`},
+		{`{{isSet . "z"}}`, `false`},
+		{`{{isSet .m "c"}}`, `true`},
+		{`{{isSet .m "z"}}`, `false`},
+		{`{{isSet .a "z"}}`, `false`},
+		{`{{isSet .b "z"}}`, `false`},
+		{`{{if isSet . "a"}}{{if not .a}}null{{end}}{{end}}`, `null`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+
+	if output, err := NewMain().RunTemplate(`{{isSet . "Index"}} {{isSet . "Key"}}`, struct{ Index int }{}); err != nil {
+		t.Fatal(err)
+	} else if output != `true false` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure slice membership can be tested with mixed numeric types.
+func TestFuncs_HasIn(t *testing.T) {
+	data := map[string]interface{}{
+		"flags": []interface{}{"auth", float64(2), nil},
+		"ints":  []int{1, 2, 3},
+	}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{hasItem .flags "auth"}}`, `true`},
+		{`{{hasItem .flags "cache"}}`, `false`},
+		{`{{hasItem .flags 2}}`, `true`},
+		{`{{hasItem .ints 2.0}}`, `true`},
+		{`{{hasItem .ints 4}}`, `false`},
+		{`{{hasItem .ints "1"}}`, `false`},
+		{`{{hasItem .flags nil}}`, `true`},
+		{`{{hasItem .missing "auth"}}`, `false`},
+		{`{{in "auth" .flags}}`, `true`},
+		{`{{if in 3 .ints}}yes{{end}}`, `yes`},
+		{`{{has "auth" .flags}}`, `true`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure a defined template can be captured and post-processed.
+func TestFuncs_Capture(t *testing.T) {
+	source := `{{define "body"}}{{range .}}{{.}}` + "\n" + `{{end}}{{end}}` +
+		`{{capture "body" . | trim | indent 4}}`
+	if output, err := NewMain().RunTemplate(source, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	} else if output != "    1\n    2" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure values can be looked up by JSON Pointer.
+func TestFuncs_JSONPointer(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{map[string]interface{}{"c": "x"}},
+		},
+		"d/e": "slash",
+		"f~g": "tilde",
+	}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{jsonPointer . "/a/b/0/c"}}`, `x`},
+		{`{{len (jsonPointer . "")}}`, `3`},
+		{`{{jsonPointer . "/d~1e"}}`, `slash`},
+		{`{{jsonPointer . "/f~0g"}}`, `tilde`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure a JSON Pointer to a missing value returns an error.
+func TestFuncs_JSONPointer_ErrNotFound(t *testing.T) {
+	data := map[string]interface{}{"a": []interface{}{1}}
+	for _, tt := range []struct {
+		source string
+		err    string
+	}{
+		{`{{jsonPointer . "/b"}}`, `json pointer "/b": key not found: "b"`},
+		{`{{jsonPointer . "/a/1"}}`, `json pointer "/a/1": array index out of range: 1`},
+		{`{{jsonPointer . "/a/01"}}`, `json pointer "/a/01": invalid array index: "01"`},
+		{`{{jsonPointer . "/a/0/x"}}`, `json pointer "/a/0/x": cannot index int with "x"`},
+		{`{{jsonPointer . "a"}}`, `invalid json pointer: "a"`},
+	} {
+		if _, err := NewMain().RunTemplate(tt.source, data); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+	}
+}
+
+// Ensure values can be encoded to YAML and decoded back.
+func TestFuncs_YAML(t *testing.T) {
+	data := map[string]interface{}{
+		"name": "bob",
+		"tags": []interface{}{"a", "b"},
+		"meta": map[string]interface{}{"age": float64(12)},
+	}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{source: `{{toYAML .}}`, output: "meta:\n  age: 12\nname: bob\ntags:\n- a\n- b"},
+		{source: `x:{{toYAML .meta | nindent 2}}`, output: "x:\n  age: 12"},
+		{source: `{{$v := toYAML . | fromYAML}}{{$v.name}} {{$v.meta.age}} {{index $v.tags 1}}`, output: `bob 12 b`},
+		{source: `{{(fromYAML "a: [1, 2]").a}}`, output: `[1 2]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.source, output)
+		}
+	}
+}
+
+// Ensure invalid YAML returns an error.
+func TestFuncs_FromYAML_ErrInvalid(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{fromYAML "a: [1"}}`, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure uuid generates a version 4 UUID from the random source.
+func TestFuncs_UUID(t *testing.T) {
+	m := NewMain()
+	m.Rand = bytes.NewReader(make([]byte, 32))
+	if output, err := m.RunTemplate(`{{uuid}} {{uuid}}`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `00000000-0000-4000-8000-000000000000 00000000-0000-4000-8000-000000000000` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure uuid generates the same values when using the same seed.
+func TestFuncs_UUID_Seed(t *testing.T) {
+	render := func(seed string) string {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-seed", seed}); err != nil {
+			t.Fatal(err)
+		}
+		output, err := m.RunTemplate(`{{uuid}}`, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(output) {
+			t.Fatalf("invalid uuid: %s", output)
+		}
+		return output
+	}
+
+	if a, b := render("1"), render("1"); a != b {
+		t.Fatalf("expected equal uuids: %s != %s", a, b)
+	} else if c := render("2"); a == c {
+		t.Fatalf("expected different uuids: %s", a)
+	}
+}
+
+// Ensure slices and maps can be enumerated with an index.
+func TestFuncs_Enumerate(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		data   interface{}
+		output string
+	}{
+		{source: `{{range enumerate .}}{{.Index}}:{{.Value}} {{end}}`, data: []interface{}{"a", "b"}, output: `0:a 1:b `},
+		{source: `{{range enumerateFrom 1 .}}{{.Index}}:{{.Value}} {{end}}`, data: []interface{}{"a", "b"}, output: `1:a 2:b `},
+		{source: `{{range enumerateFrom 1 .}}{{.Index}}:{{.Key}}={{.Value}} {{end}}`, data: map[string]interface{}{"y": 2, "x": 1}, output: `1:x=1 2:y=2 `},
+		{source: `{{enumerate .}}`, data: "abc", output: `[]`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, tt.data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure maps decoded from JSON can be iterated in source order.
+func TestFuncs_Ordered(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{
+		"-ordered-data",
+		"-data", `{"fields":{"name":"string","id":"int64","created":{"z":1,"a":2}},"one":{"x":1}}`,
+		"-template-string", `{{range ordered .fields}}{{.Index}}:{{.Key}} {{end}}{{range ordered .fields.created}}{{.Key}}={{.Value}} {{end}}{{range ordered .one}}{{.Key}}{{end}}`,
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `0:name 1:id 2:created z=1 a=2 x` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure the key order of each JSONL record is recorded separately.
+func TestFuncs_Ordered_JSONL(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-ordered-data", "-jsonl", "-record-separator", " ", "-template-string", `{{range ordered .}}{{.Key}}{{end}}`}); err != nil {
+		t.Fatal(err)
+	}
+	m.Stdin.WriteString("{\"b\":1,\"a\":2}\n{\"a\":1,\"b\":2}\n{\"c\":1,\"a\":2}\n")
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `ba ab ca` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure maps without a recorded key order cannot be iterated in order.
+func TestFuncs_Ordered_ErrUnknown(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-data", `{"x":{"b":1,"a":2}}`}, `ordered: key order is only recorded with -ordered-data`},
+		{[]string{"-ordered-data", "-data", `{"x":{"b":1,"a":2}}`, "-data", `{"x":{"c":3}}`}, `ordered: key order is unknown; only maps decoded from JSON data keep their order`},
+		{[]string{"-ordered-data", "-data", `{"x":[1]}`}, `ordered: expected map, got []interface {}`},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(append(tt.args, "-template-string", `{{ordered .x}}`)); err != nil {
+			t.Fatal(err)
+		} else if err := m.Run(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+	}
+}
+
+// Ensure numeric comparisons work across numeric types.
+func TestFuncs_NumCompare(t *testing.T) {
+	data := map[string]interface{}{"n": float64(2)}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{source: `{{numEq .n 2}}`, output: `true`},
+		{source: `{{numNe .n 2}}`, output: `false`},
+		{source: `{{numLt .n 3}}`, output: `true`},
+		{source: `{{numLe .n 2}}`, output: `true`},
+		{source: `{{numGt .n 2.5}}`, output: `false`},
+		{source: `{{numGe 3 .n}}`, output: `true`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure numeric comparisons return an error for non-numeric values.
+func TestFuncs_NumCompare_ErrNonNumeric(t *testing.T) {
+	if _, err := NewMain().RunTemplate(`{{numEq "2" 2}}`, nil); err == nil || !strings.Contains(err.Error(), `non-numeric value: "2"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure required returns present values.
+func TestFuncs_Required(t *testing.T) {
+	data := map[string]interface{}{"name": "bob", "n": float64(0)}
+	if output, err := NewMain().RunTemplate(`{{required "name required" .name}} {{required "n required" .n}}`, data); err != nil {
+		t.Fatal(err)
+	} else if output != `bob 0` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure required fails the run with the message & file path for missing or empty values.
+func TestFuncs_Required_ErrMissing(t *testing.T) {
+	for _, data := range []interface{}{
+		map[string]interface{}{},
+		map[string]interface{}{"name": nil},
+		map[string]interface{}{"name": ""},
+		map[string]interface{}{"name": []interface{}{}},
+	} {
+		if _, err := NewMain().RunTemplate(`{{required "name is required" .name}}`, data); err == nil {
+			t.Fatalf("expected error: %#v", data)
+		} else if s := err.Error(); !strings.Contains(s, "a.tmpl") || !strings.Contains(s, "name is required") {
+			t.Fatalf("unexpected error: %s", s)
+		}
+	}
+}
+
+// Ensure the types of data values can be inspected for debugging.
+func TestFuncs_TypeOf(t *testing.T) {
+	data := map[string]interface{}{"n": float64(1), "m": map[string]interface{}{}, "s": []interface{}{}}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{typeOf .n}}`, `float64`},
+		{`{{typeOf .m}}`, `map[string]interface {}`},
+		{`{{typeOf .missing}}`, `<nil>`},
+		{`{{kindOf .s}}`, `slice`},
+		{`{{kindOf .m}}`, `map`},
+	} {
+		if output, err := NewMain().RunTemplate(tt.source, data); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure fail aborts the run with the message & file path.
+func TestFuncs_Fail(t *testing.T) {
+	source := `{{if eq . "a" "b"}}ok{{else}}{{fail "unexpected kind"}}{{end}}`
+	if output, err := NewMain().RunTemplate(source, "a"); err != nil {
+		t.Fatal(err)
+	} else if output != `ok` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	if _, err := NewMain().RunTemplate(source, "c"); err == nil {
+		t.Fatal("expected error")
+	} else if s := err.Error(); !strings.Contains(s, "a.tmpl") || !strings.Contains(s, "unexpected kind") {
+		t.Fatalf("unexpected error: %s", s)
+	}
+}
+
+// Ensure file contents can be read into a template with whitespace trimmed.
+func TestFuncs_FileContents(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-allow-read", "secrets", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.tmpl":
+			return []byte(`password={{fileContents .file}}`), nil
+		case "secrets/db":
+			return []byte("s3cr3t\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if string(data) != `password=s3cr3t` {
+			t.Fatalf("unexpected data: %s", data)
+		}
+		return nil
+	}
+
+	m.Data = map[string]interface{}{"file": "secrets/db"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure data files can be read & decoded from within a template.
+func TestFuncs_FromDataFile(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-allow-read", "data"}); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"data/a.json":  `{"name":"json"}`,
+		"data/b.jsonc": "{\"name\":\"jsonc\" // comment\n}",
+		"data/c.yml":   "name: yaml\n",
+		"data/d.toml":  "name = \"toml\"\n",
+		"data/e.txt":   `{"name":"txt"}`,
+	}
+	for _, tt := range []struct {
+		source string
+		output string
+	}{
+		{`{{(fromJSONFile "data/a.json").name}}`, `json`},
+		{`{{(fromYAMLFile "data/c.yml").name}}`, `yaml`},
+		{`{{(fromTOMLFile "data/d.toml").name}}`, `toml`},
+		{`{{(fromYAMLFile "data/a.json").name}}`, `json`},
+		{`{{range $p := list "a.json" "b.jsonc" "c.yml" "d.toml" "e.txt"}}{{(fromDataFile (print "data/" $p)).name}} {{end}}`, `json jsonc yaml toml txt `},
+	} {
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			if filename == "a.tmpl" {
+				return []byte(tt.source), nil
+			} else if s, ok := files[filename]; ok {
+				return []byte(s), nil
+			}
+			return nil, os.ErrNotExist
+		}
+
+		var output string
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			output = string(data)
+			return nil
+		}
+
+		m.Paths = []string{"a.tmpl"}
+		if err := m.Run(); err != nil {
+			t.Fatalf("%s: %s", tt.source, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %s", tt.source, output)
+		}
+	}
+}
+
+// Ensure data file errors and disallowed reads are reported.
+func TestFuncs_FromDataFile_Err(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-allow-read", "data"}); err != nil {
+		t.Fatal(err)
+	} else if _, err := m.RunTemplate(`{{fromJSONFile "other/a.json"}}`, nil); err == nil || !strings.Contains(err.Error(), `read not allowed outside of -allow-read directories: other/a.json`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m = NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.tmpl" {
+			return []byte(`{{fromJSONFile "a.json"}}`), nil
+		}
+		return []byte(`{`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+	m.Paths = []string{"a.tmpl"}
+	if err := m.Run(); err == nil || !strings.Contains(err.Error(), `a.json: unexpected end of JSON input`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure files outside of allowed directories cannot be read.
+func TestFuncs_FileContents_ErrNotAllowed(t *testing.T) {
+	for _, path := range []string{"other/db", "secrets/../db", "secrets-other/db"} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-allow-read", "secrets"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.RunTemplate(`{{fileContents .}}`, path); err == nil || !strings.Contains(err.Error(), "read not allowed outside of -allow-read directories: "+path) {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goLiteral returns v as a Go expression, such as a composite literal for
+// maps & slices, with types inferred from the values:
+//
+//   - Strings, bools, and nil are written as is.
+//   - Whole numbers are ints and other numbers are float64s.
+//   - Slices are []T and maps are map[string]T, where T is the type shared by
+//     every element. If elements are a mix of ints and float64s then T is
+//     float64. Otherwise, or if there are no elements, T is interface{}.
+//
+// Map entries are written in sorted key order. Element types are omitted
+// within composite literals where Go allows it.
+func goLiteral(v interface{}) (string, error) {
+	var buf strings.Builder
+	if err := writeGoLiteral(&buf, v, "", 0); err != nil {
+		return "", fmt.Errorf("goLiteral: %s", err)
+	}
+	return buf.String(), nil
+}
+
+// writeGoLiteral writes v to buf. If elemType is set then v is an element of
+// a composite literal of that element type, which is omitted for composite
+// values of the same type.
+func writeGoLiteral(buf *strings.Builder, v interface{}, elemType string, depth int) error {
+	typ, err := goLiteralType(v)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(v)
+	if v == nil {
+		buf.WriteString("nil")
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		buf.WriteString(strconv.FormatBool(rv.Bool()))
+	case reflect.String:
+		buf.WriteString(strconv.Quote(rv.String()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteString(strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteString(strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		buf.WriteString(strconv.FormatFloat(rv.Float(), 'g', -1, 64))
+
+	case reflect.Slice, reflect.Array:
+		if typ != elemType {
+			buf.WriteString(typ)
+		}
+		itemType := strings.TrimPrefix(typ, "[]")
+		buf.WriteString("{")
+		for i := 0; i < rv.Len(); i++ {
+			writeGoLiteralIndent(buf, depth+1)
+			if err := writeGoLiteral(buf, rv.Index(i).Interface(), itemType, depth+1); err != nil {
+				return err
+			}
+			buf.WriteString(",")
+		}
+		if rv.Len() > 0 {
+			writeGoLiteralIndent(buf, depth)
+		}
+		buf.WriteString("}")
+
+	case reflect.Map:
+		if typ != elemType {
+			buf.WriteString(typ)
+		}
+		itemType := strings.TrimPrefix(typ, "map[string]")
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		buf.WriteString("{")
+		for _, k := range keys {
+			writeGoLiteralIndent(buf, depth+1)
+			buf.WriteString(strconv.Quote(k.String()) + ": ")
+			if err := writeGoLiteral(buf, rv.MapIndex(k).Interface(), itemType, depth+1); err != nil {
+				return err
+			}
+			buf.WriteString(",")
+		}
+		if len(keys) > 0 {
+			writeGoLiteralIndent(buf, depth)
+		}
+		buf.WriteString("}")
+	}
+	return nil
+}
+
+// writeGoLiteralIndent writes a newline followed by depth tabs.
+func writeGoLiteralIndent(buf *strings.Builder, depth int) {
+	buf.WriteString("\n" + strings.Repeat("\t", depth))
+}
+
+// goLiteralType returns the Go type inferred for v by goLiteral.
+func goLiteralType(v interface{}) (string, error) {
+	if v == nil {
+		return "interface{}", nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int", nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("unsupported value: %v", f)
+		} else if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return "int", nil
+		}
+		return "float64", nil
+
+	case reflect.Slice, reflect.Array:
+		types := make([]string, rv.Len())
+		for i := range types {
+			typ, err := goLiteralType(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			types[i] = typ
+		}
+		return "[]" + unifyGoLiteralTypes(types), nil
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported map key type: %s", rv.Type().Key())
+		}
+		var types []string
+		for _, k := range rv.MapKeys() {
+			typ, err := goLiteralType(rv.MapIndex(k).Interface())
+			if err != nil {
+				return "", err
+			}
+			types = append(types, typ)
+		}
+		return "map[string]" + unifyGoLiteralTypes(types), nil
+
+	default:
+		return "", fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+// unifyGoLiteralTypes returns the element type for a composite literal
+// containing values of the given types.
+func unifyGoLiteralTypes(types []string) string {
+	if len(types) == 0 {
+		return "interface{}"
+	}
+
+	typ := types[0]
+	for _, other := range types[1:] {
+		if other == typ {
+			continue
+		} else if (typ == "int" || typ == "float64") && (other == "int" || other == "float64") {
+			typ = "float64"
+		} else {
+			return "interface{}"
+		}
+	}
+	return typ
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// isFresh returns true if the output at outputPath exists and was modified
+// after the template at path, its data files and the prelude, if any, and
+// the inputs recorded for it in the previous depfile. An output is stale if
+// any of those inputs no longer exists.
+func (m *Main) isFresh(path, outputPath string) (bool, error) {
+	outFI, err := m.OS.Stat(outputPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Determine the inputs that the output depends on.
+	inputs := []string{path}
+	if m.TemplateDataPath != "" {
+		inputs = append(inputs, filepath.Join(filepath.Dir(path), m.TemplateDataPath))
+	} else {
+		inputs = append(inputs, m.DataPaths...)
+	}
+	if m.PreludePath != "" {
+		inputs = append(inputs, m.PreludePath)
+	}
+	inputs = append(inputs, m.recorded[outputPath]...)
+
+	for _, input := range inputs {
+		fi, err := m.OS.Stat(input)
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		} else if fi.ModTime().After(outFI.ModTime()) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// skipFresh records the up-to-date output at outputPath in the manifest and
+// depfile, if they are being written, without regenerating it.
+func (m *Main) skipFresh(outputPath string) error {
+	if prereqs, ok := m.recorded[outputPath]; ok {
+		m.dependencies = append(m.dependencies, dependency{target: outputPath, prereqs: prereqs})
+	}
+
+	if m.ManifestPath == "" {
+		return nil
+	}
+
+	buf, err := m.FileReadWriter.ReadFile(outputPath)
+	if err != nil {
+		return err
+	}
+	m.generated = append(m.generated, newManifestFile(outputPath, buf))
+	return nil
+}
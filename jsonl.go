@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processJSONL reads JSON records from Stdin, one per line, and renders the
+// templates once for each record as it is read. Blank lines are ignored.
+//
+// TemplateString is written to Stdout with RecordSeparator between records,
+// unless OutputPath is set. Otherwise, each output is written to its usual
+// path with the record number, starting from 1, inserted before the
+// extension. For example, "a.txt.tmpl" generates "a.1.txt", "a.2.txt", etc.
+func (m *Main) processJSONL() error {
+	// Read & parse templates up front.
+	type target struct {
+		outputPath string
+		renderer   *Renderer
+		perm       os.FileMode
+	}
+	var targets []target
+	if m.TemplateString != "" {
+		r, err := m.NewRenderer(TemplateStringName, []byte(m.TemplateString))
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target{m.OutputPath, r, 0666})
+	}
+	for _, path := range m.Paths {
+		path, outputPath := m.templatePaths(path)
+		r, perm, err := m.readTemplate(path)
+		if err != nil {
+			return err
+		} else if r != nil {
+			targets = append(targets, target{outputPath, r, perm})
+		}
+	}
+
+	r := bufio.NewReader(m.Stdin)
+	for lineNo, n := 1, 0; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			// Maps decoded for the previous record are no longer used.
+			m.keyOrders.reset()
+
+			n++
+			data, err := m.decodeData(DataFormatJSON, bytes.TrimRight(line, "\r\n"), m.JSONC)
+			if err != nil {
+				return fmt.Errorf("stdin:%d: %s", lineNo, err)
+			} else if m.SchemaPath != "" {
+				if err := m.validateSchema(m.unnestData(data)); err != nil {
+					return fmt.Errorf("stdin:%d: %s", lineNo, err)
+				}
+			}
+
+			for _, t := range targets {
+				if t.outputPath == "" {
+					if n > 1 {
+						if _, err := io.WriteString(m.Stdout, m.RecordSeparator); err != nil {
+							return err
+						}
+					}
+					if err := m.writeStdout(t.renderer, data); err != nil {
+						return err
+					}
+				} else if err := m.generate(t.renderer, numberedPath(t.outputPath, n), data, t.perm); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// numberedPath returns path with n inserted before its extension.
+func numberedPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + strconv.Itoa(n) + ext
+}
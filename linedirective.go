@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// lineMarker starts the source positions embedded in rendered output when
+// LineDirectives is set. Markers are "\x00tmpl-<nonce>:name:line\x00" and
+// are removed from the output before it is written. The nonce is random so
+// that NUL bytes in data are not mistaken for a marker.
+var lineMarker = newLineMarker()
+
+func newLineMarker() []byte {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return []byte("\x00tmpl-" + hex.EncodeToString(b[:]) + ":")
+}
+
+// generatesGo returns true if the template at path renders Go source, which
+// is the only output that line markers are inserted into.
+func (m *Main) generatesGo(path string) bool {
+	return filepath.Ext(strings.TrimSuffix(path, Extension)) == ".go" || filepath.Ext(m.outputPath(path)) == ".go"
+}
+
+// annotateLines inserts a marker with the source position into the text of
+// tmpl and its associated templates at the start of each text node and after
+// each newline within one.
+func annotateLines(tmpl *template.Template) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			annotateNode(t.Tree, t.Tree.Root)
+		}
+	}
+}
+
+func annotateNode(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			annotateNode(tree, child)
+		}
+	case *parse.IfNode:
+		annotateBranch(tree, &n.BranchNode)
+	case *parse.RangeNode:
+		annotateBranch(tree, &n.BranchNode)
+	case *parse.WithNode:
+		annotateBranch(tree, &n.BranchNode)
+	case *parse.TextNode:
+		// Determine the source line from the "name:line:col" location.
+		location, _ := tree.ErrorContext(n)
+		location = location[:strings.LastIndexByte(location, ':')]
+		i := strings.LastIndexByte(location, ':')
+		name, line := location[:i], location[i+1:]
+		lineNo, err := strconv.Atoi(line)
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		for i, text := range bytes.SplitAfter(n.Text, []byte("\n")) {
+			if len(text) == 0 {
+				continue
+			}
+			buf.Write(lineMarker)
+			buf.WriteString(name + ":" + strconv.Itoa(lineNo+i))
+			buf.WriteByte(0)
+			buf.Write(text)
+		}
+		n.Text = buf.Bytes()
+	}
+}
+
+func annotateBranch(tree *parse.Tree, n *parse.BranchNode) {
+	if n.List != nil {
+		annotateNode(tree, n.List)
+	}
+	if n.ElseList != nil {
+		annotateNode(tree, n.ElseList)
+	}
+}
+
+// linePosition is the template source position of a line of output.
+type linePosition struct {
+	name string
+	line int
+}
+
+// stripLineMarkers removes the line markers from output and returns it with
+// the source position of the first marker on each line, if any.
+func stripLineMarkers(output []byte) ([]byte, []*linePosition) {
+	lines := bytes.Split(output, []byte("\n"))
+	positions := make([]*linePosition, len(lines))
+	for i, line := range lines {
+		var buf bytes.Buffer
+		for {
+			start := bytes.Index(line, lineMarker)
+			if start == -1 {
+				break
+			}
+			n := bytes.IndexByte(line[start+len(lineMarker):], 0)
+			if n == -1 {
+				break
+			}
+			end := start + len(lineMarker) + n
+			if positions[i] == nil {
+				location := string(line[start+len(lineMarker) : end])
+				j := strings.LastIndexByte(location, ':')
+				lineNo, _ := strconv.Atoi(location[j+1:])
+				positions[i] = &linePosition{name: location[:j], line: lineNo}
+			}
+			buf.Write(line[:start])
+			line = line[end+1:]
+		}
+		buf.Write(line)
+		lines[i] = buf.Bytes()
+	}
+	return bytes.Join(lines, []byte("\n")), positions
+}
+
+// formatGo formats the Go source in output. If LineDirectives is set then
+// line markers are replaced by //line directives in the formatted source.
+// On error, the unformatted source is returned along with the error.
+func (m *Main) formatGo(outputPath string, output []byte) ([]byte, error) {
+	if !m.LineDirectives {
+		formatted, err := format.Source(output)
+		if err != nil {
+			return output, err
+		}
+		return formatted, nil
+	}
+
+	src, positions := stripLineMarkers(output)
+	formatted, err := format.Source(src)
+	if err != nil {
+		return insertLineDirectives(outputPath, src, positions), err
+	}
+
+	// Format again as gofmt separates directives from doc comments. Each
+	// directive still precedes the line it applies to.
+	output = insertLineDirectives(outputPath, formatted, alignLines(src, formatted, positions))
+	formatted, err = format.Source(output)
+	if err != nil {
+		return output, err
+	}
+	return formatted, nil
+}
+
+// alignLines returns the positions of the lines of formatted, given the
+// positions of the lines of src that it was formatted from. Lines are matched
+// in order ignoring whitespace, so lines that gofmt moves, such as sorted
+// imports, may have no position.
+func alignLines(src, formatted []byte, positions []*linePosition) []*linePosition {
+	srcLines := bytes.Split(src, []byte("\n"))
+	lines := bytes.Split(formatted, []byte("\n"))
+	other := make([]*linePosition, len(lines))
+	for i, j := 0, 0; i < len(lines); i++ {
+		key := removeSpace(lines[i])
+		if len(key) == 0 {
+			continue
+		}
+		for k := j; k < len(srcLines); k++ {
+			if bytes.Equal(key, removeSpace(srcLines[k])) {
+				other[i], j = positions[k], k+1
+				break
+			}
+		}
+	}
+	return other
+}
+
+// removeSpace returns b without any whitespace.
+func removeSpace(b []byte) []byte {
+	return bytes.Join(bytes.Fields(b), nil)
+}
+
+// insertLineDirectives writes a //line directive before each line of the Go
+// source in src whose position does not follow on from the previous line,
+// so that compiler errors and stack traces refer to the template source.
+//
+// Directives are only written before lines of code, which excludes blank
+// lines, comments, and lines within multi-line raw strings. Relative
+// template paths are written relative to the output's directory, as the
+// compiler expects.
+func insertLineDirectives(outputPath string, src []byte, positions []*linePosition) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+
+	// Find lines within tokens that span lines, such as raw strings.
+	inToken := make([]bool, len(lines))
+	file := token.NewFileSet().AddFile(outputPath, -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		start := file.Line(pos) - 1
+		for i := 1; i <= strings.Count(lit, "\n") && start+i < len(inToken); i++ {
+			inToken[start+i] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	var cur linePosition
+	for i, line := range lines {
+		if p := positions[i]; p != nil && !inToken[i] && isCodeLine(line) && *p != cur {
+			name := p.name
+			if !filepath.IsAbs(name) {
+				if rel, err := filepath.Rel(filepath.Dir(outputPath), name); err == nil {
+					name = filepath.ToSlash(rel)
+				}
+			}
+
+			fmt.Fprintf(&buf, "//line %s:%d\n", name, p.line)
+			cur = *p
+		}
+		buf.Write(line)
+		if i < len(lines)-1 {
+			buf.WriteByte('\n')
+		}
+		cur.line++
+	}
+	return buf.Bytes()
+}
+
+// isCodeLine returns true if line is not blank and not a comment.
+func isCodeLine(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) > 0 && !bytes.HasPrefix(line, []byte("//")) && !bytes.HasPrefix(line, []byte("/*"))
+}
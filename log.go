@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// ANSI escape codes used to colorize output.
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// Diagnostic kinds.
+const (
+	DiagnosticError    = "error"
+	DiagnosticTemplate = "template"
+	DiagnosticWarning  = "warning"
+)
+
+// Diagnostic is an error or warning written to Stderr as JSON when
+// ErrorsAsJSON is set. File, Line, and Column are set when the message
+// refers to a position within a template.
+type Diagnostic struct {
+	Kind    string `json:"kind"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// templateErrorRegex matches the position prefix of text/template errors.
+var templateErrorRegex = regexp.MustCompile(`(?s)^template: (.+?):(\d+)(?::(\d+))?: (.*)$`)
+
+// newDiagnostic returns a diagnostic for msg. Template errors are parsed for
+// their file & position.
+func newDiagnostic(kind, msg string) Diagnostic {
+	a := templateErrorRegex.FindStringSubmatch(msg)
+	if a == nil {
+		return Diagnostic{Kind: kind, Message: msg}
+	}
+
+	d := Diagnostic{Kind: DiagnosticTemplate, File: a[1], Message: a[4]}
+	d.Line, _ = strconv.Atoi(a[2])
+	d.Column, _ = strconv.Atoi(a[3])
+	return d
+}
+
+// PrintError writes err to Stderr.
+func (m *Main) PrintError(err error) {
+	if m.ErrorsAsJSON {
+		m.printDiagnostic(newDiagnostic(DiagnosticError, err.Error()))
+		return
+	}
+	fmt.Fprintln(m.Stderr, m.colorize(colorRed, err.Error()))
+}
+
+// warnf writes a formatted warning to Stderr. All warnings must be emitted
+// through warnf so they are counted for Werror.
+func (m *Main) warnf(format string, v ...interface{}) {
+	m.warnings++
+	if m.ErrorsAsJSON {
+		m.printDiagnostic(Diagnostic{Kind: DiagnosticWarning, Message: fmt.Sprintf(format, v...)})
+		return
+	}
+	fmt.Fprintln(m.Stderr, m.colorize(colorYellow, "warning:"), fmt.Sprintf(format, v...))
+}
+
+// printDiagnostic writes d to Stderr as a single line of JSON.
+func (m *Main) printDiagnostic(d Diagnostic) {
+	buf, _ := json.Marshal(d)
+	fmt.Fprintln(m.Stderr, string(buf))
+}
+
+// colorize wraps s in the given color code if color output is enabled.
+func (m *Main) colorize(color, s string) string {
+	if !m.useColor() {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// useColor returns true if errors & warnings should be colorized.
+func (m *Main) useColor() bool {
+	switch m.Color {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		return m.OS.Getenv("NO_COLOR") == "" && isTerminal(m.Stderr)
+	default:
+		return false
+	}
+}
+
+// isTerminal returns true if w is a file attached to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -1,35 +1,112 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
+	crand "crypto/rand"
 	"errors"
 	"flag"
 	"fmt"
-	"go/format"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
-	"github.com/Masterminds/sprig"
 	"github.com/dustin/go-humanize/english"
 )
 
 // Extension is the required file extension for processed files.
 const Extension = ".tmpl"
 
+// TemplateStringName is the source name used for templates passed inline.
+const TemplateStringName = "<template-string>"
+
+// GeneratedMarker identifies files with a standard Go
+// "Code generated ... DO NOT EDIT." header.
+const GeneratedMarker = "Code generated"
+
+// DefaultHeaderMarker is written to the first line of generated file headers.
+const DefaultHeaderMarker = "Generated"
+
+// HeaderCommentPrefixes are the built-in line comment prefixes used to write
+// the generated file header, keyed by output file extension.
+var HeaderCommentPrefixes = map[string]string{
+	".c":     "//",
+	".cpp":   "//",
+	".go":    "//",
+	".h":     "//",
+	".java":  "//",
+	".js":    "//",
+	".proto": "//",
+	".rs":    "//",
+	".ts":    "//",
+	".bash":  "#",
+	".py":    "#",
+	".rb":    "#",
+	".sh":    "#",
+	".toml":  "#",
+	".yaml":  "#",
+	".yml":   "#",
+	".lua":   "--",
+	".sql":   "--",
+}
+
+// OutExt is a rule that generates templates with the extension From, which
+// includes the template extension, to paths with the extension To.
+type OutExt struct {
+	From string
+	To   string
+}
+
+// PermRule sets the mode of generated paths ending with Ext.
+type PermRule struct {
+	Ext  string
+	Perm os.FileMode
+}
+
+// Behaviors for handling paths that do not exist.
+const (
+	MissingFileError = "error"
+	MissingFileSkip  = "skip"
+)
+
+// Behaviors for handling errors generating an output.
+const (
+	OnErrorFailFast  = "fail-fast"
+	OnErrorAggregate = "aggregate"
+)
+
+// Color modes for error & warning output.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
 func main() {
 	m := NewMain()
 	if err := m.ParseFlags(os.Args[1:]); err != nil {
-		fmt.Fprintln(m.Stderr, err)
+		m.PrintError(err)
 		os.Exit(2)
 	}
 
-	if err := m.Run(); err != nil {
-		fmt.Fprintln(m.Stderr, err)
+	run := m.Run
+	if m.Watch {
+		run = func() error { return m.RunWatch(time.NewTicker(m.WatchInterval).C) }
+	}
+	if err := run(); err != nil {
+		m.PrintError(err)
 		os.Exit(1)
 	}
 }
@@ -38,25 +115,335 @@ type Main struct {
 	// Files to be processed.
 	Paths []string
 
+	// Template source passed inline instead of Paths.
+	TemplateString string
+
+	// If true, templates are rendered once for each JSON record read from
+	// Stdin, one per line. Outputs of TemplateString written to Stdout are
+	// separated by RecordSeparator.
+	JSONL           bool
+	RecordSeparator string
+
+	// If true, the root data must be an array and each template is rendered
+	// once per element, with the results concatenated into a single output
+	// and separated by RecordSeparator.
+	Foreach bool
+
+	// If DataDir is set, the template at TemplatePath is rendered once for
+	// each data file in DataDir to an output in OutDir, instead of Paths.
+	TemplatePath string
+	DataDir      string
+	OutDir       string
+
+	// If set, paths without a directory are the names of templates in
+	// TemplateDir, with or without the template extension. These generate
+	// to the current directory, or to OutDir if set.
+	TemplateDir string
+
 	NoHeader   bool
 	OutputPath string
 
+	// If true, the generated file header is written to all outputs with a
+	// known comment prefix instead of only to Go files.
+	HeaderAll bool
+
+	// Comment prefixes keyed by output file extension. These override
+	// HeaderCommentPrefixes.
+	HeaderComments map[string]string
+
+	// Styles of generated notice, NoticeKey or NoticeFile, keyed by output
+	// file extension, for formats without comments.
+	GeneratedNotices map[string]string
+
+	// Suffix inserted into generated paths before the final extension.
+	OutSuffix string
+
+	// Name of the character encoding that outputs are transcoded to from
+	// UTF-8, e.g. "windows-1252". Outputs are UTF-8 if empty.
+	Encoding string
+
+	// Rules replacing the extension of template paths in generated paths.
+	// The first rule matching a path is applied.
+	OutExts []OutExt
+
+	// Rules setting the mode of generated files by their extension instead
+	// of using the template's mode. The first rule matching a path is
+	// applied, including to existing files.
+	Perms []PermRule
+
+	// If set, templates defined in this file are available to every template.
+	PreludePath string
+
+	// Text written before & after the rendered output of each template.
+	// These are written after the generated file header, if any.
+	Prefix string
+	Suffix string
+
+	// Behavior when a path does not exist. Defaults to MissingFileError.
+	OnMissingFile string
+
+	// Behavior when generating an output fails. Defaults to OnErrorFailFast,
+	// which stops at the first error. OnErrorAggregate writes each error to
+	// Stderr and continues with the remaining paths and data files.
+	OnError string
+
+	// If set, a manifest of generated files is written to this path.
+	ManifestPath string
+
+	// If true, files listed in the previous manifest at ManifestPath that
+	// are no longer generated are removed, unless they have been modified.
+	Prune bool
+
+	// If set, a Makefile listing the files each output was generated from
+	// is written to this path.
+	DepfilePath string
+
+	// If true, outputs are written under a new temporary directory instead
+	// of to their output paths. The directory and the template & temporary
+	// path of each output are written to Stdout.
+	OutputDirTemp bool
+
+	// If true, existing output files are only overwritten if they
+	// contain the generated file marker.
+	OverwriteProtect bool
+
+	// Marker written to the generated file header and used by
+	// OverwriteProtect to identify generated files. Defaults to
+	// DefaultHeaderMarker.
+	HeaderMarker string
+
+	// If true, existing output files are copied to the output path with
+	// BackupSuffix appended before being overwritten.
+	Backup       bool
+	BackupSuffix string
+
+	// If true, outputs are compared against existing files instead of being
+	// written and Run returns an error listing any files that differ.
+	Verify bool
+
+	// If true, outputs are compared against existing files instead of being
+	// written and a unified diff is written to Stdout for each that differs.
+	Diff bool
+
+	// If true, outputs newer than both their template and data file are not
+	// regenerated.
+	Incremental bool
+
+	// If set, only templates whose template or data file changed since this
+	// git ref are regenerated.
+	Since string
+
+	// If true, main calls RunWatch to reprocess paths when their inputs
+	// change, checking every WatchInterval.
+	Watch         bool
+	WatchInterval time.Duration
+
+	// If greater than zero, outputs larger than this many bytes are not written.
+	MaxSize int64
+
+	// If greater than zero, executing a template for longer than this is an
+	// error.
+	Timeout time.Duration
+
+	// If true, invalid UTF-8 in templates and Go output is an error instead
+	// of a warning.
+	StrictUTF8 bool
+
+	// If true, consecutive blank lines in rendered output are collapsed.
+	CollapseBlankLines bool
+
+	// If true, referencing a map key that is not present is an error rather
+	// than rendering "<no value>". Keys present with a null value are allowed.
+	StrictMissingData bool
+
+	// If true, generated Go files must parse as complete Go source files.
+	CheckGoCompiles bool
+
+	// If true, //line directives mapping Go output back to the template
+	// source are written to generated Go files.
+	LineDirectives bool
+
+	// Fence markers, such as "```", within which template whitespace trim
+	// markers are ignored so that fenced code blocks are kept verbatim.
+	VerbatimFences []string
+
+	// External formatter commands keyed by output file extension.
+	// Output is passed to the command on stdin and replaced by its stdout.
+	Formatters map[string]string
+
+	// If set, this command is run once after a successful run that wrote at
+	// least one file. The written paths are passed on stdin, one per line.
+	PostHook string
+
+	// If set, the array under this key in Data is split so that each item is
+	// rendered to a separate output. Output paths are generated by executing
+	// SplitName against each item and are relative to the template directory.
+	SplitBy   string
+	SplitName string
+
+	// If true, each template defined within a template whose name has
+	// DefineFilePrefix is written to the path following the prefix, relative
+	// to the template directory. The output of the template itself is not
+	// written.
+	DefineFiles bool
+
+	// If true, Run returns an error if any warnings were emitted.
+	Werror bool
+
+	// If true, templates are parsed without being rendered and Run returns an
+	// error if any fail to parse. Data is not required.
+	ValidateOnly bool
+
+	// Number of paths to process concurrently. Paths are processed
+	// sequentially if this is less than 2.
+	Parallel int
+
+	// If true, log output of parallel workers is buffered and written in
+	// path order once all paths are processed.
+	ParallelOrder bool
+
+	// If true, errors & warnings are written to Stderr as JSON diagnostics.
+	ErrorsAsJSON bool
+
+	// Colorization of errors & warnings. Defaults to ColorAuto which only
+	// colorizes when Stderr is a terminal and NO_COLOR is not set.
+	Color string
+
 	// Data to be applied to the files during generation.
 	Data interface{}
 
+	// Build metadata, such as a version or commit, returned by the meta
+	// function. This is kept separate from Data so keys cannot collide.
+	Meta map[string]string
+
+	// Extra arguments following "--" on the command line, returned by the
+	// args function.
+	Args []string
+
+	// If true, nil Data is treated as an empty map so that templates can
+	// reference optional fields when no data is provided.
+	AllowMissingData bool
+
+	// Paths of the files Data was read from, if any.
+	DataPaths []string
+
+	// Strategy for combining arrays when merging multiple data sources.
+	MergeStrategy string
+
+	// If set, data is read for each template from this path relative to the
+	// template's directory instead of using Data.
+	TemplateDataPath string
+
+	// If true, comments are allowed in JSON data.
+	JSONC bool
+
+	// If true, the key order of JSON objects is recorded as data is decoded
+	// so that the ordered function can iterate maps in source order.
+	OrderedData bool
+
+	// If set, decoded data is nested under this key.
+	DataKey string
+
+	// If set, Data is validated against the JSON Schema at this path.
+	SchemaPath string
+
+	// If set, template functions may only read files within these directories.
+	AllowedReadDirs []string
+
+	// Initialisms recognized by goName in addition to GoInitialisms.
+	Initialisms []string
+
+	// Source of randomness for template functions such as uuid.
+	Rand io.Reader
+
+	// Additional functions available to templates. These override built-in
+	// functions with the same name.
+	Funcs template.FuncMap
+
+	// Paths of Go plugins whose exported Funcs functions return additional
+	// functions for templates. These are loaded into Funcs by ParseFlags.
+	Plugins []string
+
+	// If true, Funcs and Output are safe to call concurrently. Otherwise,
+	// calls to them are serialized when processing in parallel.
+	ConcurrentFuncs bool
+
+	// If set, generated outputs, along with the manifest, depfile, generated
+	// notice files, and backups, are passed to Output instead of being written
+	// with FileReadWriter. Outputs are always buffered rather than streamed
+	// when set. Existing outputs are still read, and pruned files removed, as
+	// usual.
+	Output func(path string, data []byte, perm os.FileMode) error
+
 	OS interface {
 		Stat(filename string) (os.FileInfo, error)
+		Getenv(key string) string
+		Executable() (string, error)
+		MkdirAll(path string, perm os.FileMode) error
+		Chmod(name string, mode os.FileMode) error
+		Remove(name string) error
+		TempDir(dir, pattern string) (string, error)
+		ReadDir(dirname string) ([]os.FileInfo, error)
 	}
 
 	FileReadWriter interface {
 		ReadFile(filename string) ([]byte, error)
 		WriteFile(filename string, data []byte, perm os.FileMode) error
+		WriteFileFunc(filename string, perm os.FileMode, fn func(w io.Writer) error) error
+	}
+
+	CommandRunner interface {
+		RunCommand(command string, stdin io.Reader, stdout, stderr io.Writer) error
 	}
 
 	// Standard input/output
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Files generated during the current run.
+	generated []ManifestFile
+
+	// Paths written during the current run.
+	written []string
+
+	// Files read for the template currently being processed, when
+	// DepfilePath or Watch is set, and the dependencies of each output
+	// written when DepfilePath is set.
+	inputs       []string
+	dependencies []dependency
+
+	// Inputs of each path when Watch is set, keyed by path.
+	watched map[string][]string
+
+	// Files changed since the git ref Since, or nil if unknown.
+	changed map[string]bool
+
+	// Dependencies of each output recorded in DepfilePath by the previous
+	// run, used to check the freshness of outputs that are not regenerated.
+	recorded map[string][]string
+
+	// Files found to be out of date in verify mode.
+	outOfDate []string
+
+	// Temporary output directory when OutputDirTemp is set.
+	tempDir string
+
+	// Unparsed contents of the most recently decoded data source. Nil if
+	// data was merged from multiple sources.
+	raw []byte
+
+	// Contents of the prelude at PreludePath, once read.
+	prelude []byte
+
+	// Key order of decoded JSON objects when OrderedData is set.
+	keyOrders *keyOrders
+
+	// Number of warnings emitted.
+	warnings int
+
+	// Number of errors written to Stderr in aggregate mode.
+	failures int
 }
 
 // NewMain returns a new instance of Main.
@@ -64,6 +451,8 @@ func NewMain() *Main {
 	return &Main{
 		OS:             &mainOS{},
 		FileReadWriter: &fileReadWriter{},
+		CommandRunner:  &commandRunner{},
+		Rand:           crand.Reader,
 
 		Stdin:  os.Stdin,
 		Stdout: os.Stdout,
@@ -75,145 +464,1536 @@ func NewMain() *Main {
 func (m *Main) ParseFlags(args []string) error {
 	fs := flag.NewFlagSet("tmp", flag.ContinueOnError)
 	fs.SetOutput(m.Stderr)
-	data := fs.String("data", "", "json data")
+	var data []string
+	fs.Var((*stringSlice)(&data), "data", "json data, @file, or - for stdin; merged in order if repeated")
+	fs.BoolVar(&m.AllowMissingData, "allow-missing-data", false, "treat missing data as an empty map")
+	fs.StringVar(&m.MergeStrategy, "data-merge-strategy", MergeReplace, "how arrays combine when merging repeated -data: replace, append, or unique-append")
+	dataCmd := fs.String("data-cmd", "", "command to generate json data")
+	dataRelToTmpl := fs.Bool("data-relative-to-template", false, "read -data=@path relative to each template's directory")
+	fs.BoolVar(&m.JSONC, "jsonc", false, "allow comments in json data")
+	fs.BoolVar(&m.OrderedData, "ordered-data", false, "record the key order of json objects for the ordered function")
+	fs.StringVar(&m.DataKey, "data-key", "", "nest data under this key before rendering")
+	stdinFormat := fs.String("stdin-format", DataFormatJSON, "format of data read from stdin with -data -: json, yaml, or toml")
+	fs.StringVar(&m.SchemaPath, "schema", "", "json schema file to validate data against")
 	fs.BoolVar(&m.NoHeader, "no-header", false, "hide warning header")
+	fs.BoolVar(&m.HeaderAll, "header-all", false, "write warning header to all output types with a known comment prefix")
+	notices := fs.String("generated-notice", "", "comma-separated generated notice styles by extension for formats without comments: key or file (e.g. .json=key,.csv=file)")
+	headerComments := fs.String("header-comment", "", "comma-separated header comment prefixes by extension (e.g. .sql=--,.ini=;)")
 	fs.StringVar(&m.OutputPath, "o", "", "output file")
+	fs.StringVar(&m.OutSuffix, "out-suffix", "", "suffix to insert before the output file extension")
+	fs.StringVar(&m.Encoding, "encoding", "utf-8", "character encoding of outputs (e.g. windows-1252, shift_jis)")
+	var meta, outExts, permMaps []string
+	fs.Var((*stringSlice)(&permMaps), "perm-map", "comma-separated output modes by extension (e.g. .sh=0755,.go=0644); first match applies (repeatable)")
+	fs.Var((*stringSlice)(&meta), "meta", "build metadata key=value available to templates via meta (repeatable)")
+	fs.Var((*stringSlice)(&outExts), "out-ext", "comma-separated output extension rules (e.g. .js.tmpl=.mjs); first match applies (repeatable)")
+	fs.StringVar(&m.PreludePath, "prelude", "", "file of defines available to every template")
+	fs.Var((*stringSlice)(&m.Plugins), "plugin", "go plugin exporting a Funcs() template.FuncMap function (repeatable)")
+	prefix := fs.String("prefix", "", "text or @file to write before each output")
+	suffix := fs.String("suffix", "", "text or @file to write after each output")
+	fs.BoolVar(&m.JSONL, "jsonl", false, "render once per JSON record read from stdin, one per line")
+	fs.StringVar(&m.RecordSeparator, "record-separator", "", "text written between records on stdout with -jsonl or between elements with -foreach")
+	fs.BoolVar(&m.Foreach, "foreach", false, "render once per element of array data into a single output")
+	fs.StringVar(&m.TemplatePath, "template", "", "template rendered once per data file with -data-dir")
+	fs.StringVar(&m.DataDir, "data-dir", "", "directory of data files to render -template with")
+	fs.StringVar(&m.OutDir, "outdir", "", "output directory with -data-dir or -template-dir")
+	fs.StringVar(&m.TemplateDir, "template-dir", "", "directory to resolve template names without a directory against")
+	fs.StringVar(&m.TemplateString, "template-string", "", "inline template, written to stdout unless -o is set")
+	fs.StringVar(&m.OnMissingFile, "on-missing-file", MissingFileError, "behavior for missing paths: error or skip")
+	fs.StringVar(&m.OnError, "on-error", OnErrorFailFast, "behavior for failed outputs: fail-fast or aggregate")
+	fs.StringVar(&m.ManifestPath, "manifest", "", "write manifest of generated files")
+	fs.BoolVar(&m.Prune, "prune", false, "remove files in the previous manifest that are no longer generated")
+	fs.StringVar(&m.DepfilePath, "depfile", "", "write Makefile rules listing the dependencies of generated files")
+	fs.BoolVar(&m.OutputDirTemp, "output-dir-temp", false, "write outputs under a new temporary directory and print their paths")
+	fs.BoolVar(&m.OverwriteProtect, "overwrite-protect", false, "refuse to overwrite files not generated by tmpl")
+	fs.StringVar(&m.HeaderMarker, "header-marker", "", "marker identifying generated files in headers & for -overwrite-protect")
+	fs.BoolVar(&m.Backup, "backup", false, "copy existing output files before overwriting them")
+	fs.StringVar(&m.BackupSuffix, "backup-suffix", ".bak", "suffix appended to backup file paths")
+	fs.Int64Var(&m.MaxSize, "max-size", 0, "maximum output file size in bytes (0 for no limit)")
+	fs.DurationVar(&m.Timeout, "timeout", 0, "maximum time to execute each template, e.g. 30s (0 for no limit)")
+	fs.BoolVar(&m.StrictUTF8, "strict-utf8", false, "treat invalid UTF-8 as an error")
+	fs.BoolVar(&m.CollapseBlankLines, "collapse-blank-lines", false, "collapse consecutive blank lines in output")
+	fs.BoolVar(&m.StrictMissingData, "strict-missing-data", false, "fail when referencing absent map keys; null values are allowed")
+	fs.BoolVar(&m.CheckGoCompiles, "check-go-compiles", false, "fail if generated Go does not parse as a complete Go file")
+	fs.BoolVar(&m.LineDirectives, "line-directives", false, "write //line directives mapping Go output to template lines")
+	fs.StringVar(&m.PostHook, "post-hook", "", "command run after generating, with written paths on stdin")
+	formatters := fs.String("format", "", "comma-separated formatter commands by extension (e.g. .json=jq,.sql=pgformat)")
+	fs.StringVar(&m.SplitBy, "split-by", "", "data key of array to generate one output per item")
+	fs.StringVar(&m.SplitName, "split-name", "", "template for output path of each item when using -split-by")
+	fs.BoolVar(&m.DefineFiles, "define-files", false, "write each define with a file name to its own output")
+	fs.BoolVar(&m.Incremental, "incremental", false, "skip outputs newer than their template & data file")
+	fs.StringVar(&m.Since, "since", "", "only regenerate templates whose template or data file changed since a git ref")
+	fs.BoolVar(&m.Watch, "watch", false, "regenerate templates when they or the files they read change")
+	fs.DurationVar(&m.WatchInterval, "watch-interval", time.Second, "how often -watch checks for changes")
+	fs.BoolVar(&m.Verify, "verify", false, "verify generated files are up to date without writing")
+	fs.BoolVar(&m.Diff, "diff", false, "print a unified diff of each generated file that would change without writing")
+	fs.BoolVar(&m.Werror, "werror", false, "treat warnings as errors")
+	fs.BoolVar(&m.ValidateOnly, "validate-only", false, "parse templates & report errors without rendering")
+	fs.IntVar(&m.Parallel, "parallel", 1, "number of templates to process concurrently")
+	fs.BoolVar(&m.ParallelOrder, "parallel-order", false, "write log output of parallel workers in path order")
+	fs.BoolVar(&m.ConcurrentFuncs, "concurrent-funcs", false, "call plugin functions concurrently with -parallel")
+	fs.BoolVar(&m.ErrorsAsJSON, "errors-as-json", false, "write errors & warnings as JSON objects")
+	fs.StringVar(&m.Color, "color", ColorAuto, "colorize errors & warnings: auto, always, or never")
+	fs.Var((*stringSlice)(&m.AllowedReadDirs), "allow-read", "directory template functions may read files from (repeatable)")
+	fs.Var((*stringSlice)(&m.VerbatimFences), "verbatim-fence", "fence marker, e.g. ```, within which whitespace trim markers are ignored (repeatable)")
+	fs.Var((*stringSlice)(&m.Initialisms), "initialism", "additional initialism for goName, e.g. K8S (repeatable)")
+	seed := fs.Int64("seed", 0, "seed for deterministic random values")
+	relToExe := fs.Bool("relative-to-exe", false, "resolve relative template & data paths against the executable's directory")
+	expandEnv := fs.Bool("expand-env", false, "expand $VAR and ${VAR} in paths & @file arguments")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Parse JSON data.
-	if *data != "" {
-		// If the data has a @-prefix then read from a file.
-		buf := []byte(*data)
-		if strings.HasPrefix(*data, "@") {
-			b, err := m.FileReadWriter.ReadFile(strings.TrimPrefix(*data, "@"))
+	// Expand environment variables in paths, if requested. Values other than
+	// @file references, such as inline data, are left unchanged.
+	if *expandEnv {
+		paths := []*string{&m.OutputPath, &m.TemplatePath, &m.DataDir, &m.OutDir, &m.TemplateDir, &m.PreludePath, &m.SchemaPath, &m.ManifestPath, &m.DepfilePath}
+		for i := range m.AllowedReadDirs {
+			paths = append(paths, &m.AllowedReadDirs[i])
+		}
+		for _, p := range []*string{prefix, suffix} {
+			if strings.HasPrefix(*p, "@") {
+				paths = append(paths, p)
+			}
+		}
+		for i, v := range data {
+			if strings.HasPrefix(v, "@") {
+				paths = append(paths, &data[i])
+			}
+		}
+		for _, p := range paths {
+			v, err := m.expandEnv(*p)
 			if err != nil {
 				return err
 			}
-			buf = b
+			*p = v
 		}
+	}
 
-		if err := json.Unmarshal(buf, &m.Data); err != nil {
+	// Determine the base directory for relative template & data paths.
+	var baseDir string
+	if *relToExe {
+		exe, err := m.OS.Executable()
+		if err != nil {
 			return err
 		}
+		baseDir = filepath.Dir(exe)
+	}
+	for i := range m.AllowedReadDirs {
+		m.AllowedReadDirs[i] = resolvePath(baseDir, m.AllowedReadDirs[i])
 	}
 
-	// All arguments are considered paths to process.
-	m.Paths = fs.Args()
+	// Read prefix & suffix text, which may reference files.
+	var err error
+	if m.Prefix, err = m.readFlagValue(*prefix); err != nil {
+		return err
+	} else if m.Suffix, err = m.readFlagValue(*suffix); err != nil {
+		return err
+	}
 
-	return nil
-}
+	// Use a deterministic source of randomness if a seed is provided.
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			m.Rand = rand.New(rand.NewSource(*seed))
+		}
+	})
 
-// Run executes the program.
-func (m *Main) Run() error {
-	// Verify we have at least one path.
-	if len(m.Paths) == 0 {
-		return errors.New("path required")
+	// Parse external formatters & header comment prefixes.
+	if *formatters != "" {
+		if m.Formatters, err = parseExtensionMap("format", *formatters); err != nil {
+			return err
+		}
+	}
+	if *headerComments != "" {
+		if m.HeaderComments, err = parseExtensionMap("header-comment", *headerComments); err != nil {
+			return err
+		}
+	}
+	if *notices != "" {
+		if m.GeneratedNotices, err = parseExtensionMap("generated-notice", *notices); err != nil {
+			return err
+		}
+		for ext, style := range m.GeneratedNotices {
+			if style != NoticeKey && style != NoticeFile {
+				return fmt.Errorf("invalid -generated-notice style: %s=%s", ext, style)
+			}
+		}
 	}
 
-	// Process each path.
-	for _, path := range m.Paths {
-		if err := m.process(path); err != nil {
+	// Parse build metadata.
+	for _, v := range meta {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid -meta value: %s", v)
+		}
+		if m.Meta == nil {
+			m.Meta = make(map[string]string)
+		}
+		m.Meta[v[:i]] = v[i+1:]
+	}
+
+	// Parse output mode rules, in order.
+	for _, v := range permMaps {
+		rules, err := parsePermRules(v)
+		if err != nil {
 			return err
 		}
+		m.Perms = append(m.Perms, rules...)
 	}
 
-	return nil
-}
+	// Parse output extension rules, in order.
+	for _, v := range outExts {
+		rules, err := parseOutExts(v)
+		if err != nil {
+			return err
+		}
+		m.OutExts = append(m.OutExts, rules...)
+	}
 
-// process reads a template file from path, processes it, and writes it to its generated path.
-func (m *Main) process(path string) error {
-	// Validate that we have a prefix we can strip off for the generated path.
-	if !strings.HasSuffix(path, Extension) {
-		return fmt.Errorf("path must have %s extension: %s", Extension, path)
+	// Validate missing file behavior.
+	switch m.OnMissingFile {
+	case MissingFileError, MissingFileSkip:
+	default:
+		return fmt.Errorf("invalid -on-missing-file value: %s", m.OnMissingFile)
 	}
-	outputPath := m.OutputPath
-	if outputPath == "" {
-		outputPath = strings.TrimSuffix(path, Extension)
+
+	// Validate error behavior.
+	switch m.OnError {
+	case OnErrorFailFast, OnErrorAggregate:
+	default:
+		return fmt.Errorf("invalid -on-error value: %s", m.OnError)
 	}
 
-	// Stat the file to retrieve the mode.
-	fi, err := m.OS.Stat(path)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("file not found")
-	} else if err != nil {
+	// Validate output encoding.
+	if _, err := m.outputEncoding(); err != nil {
 		return err
 	}
 
-	// Read in template file.
-	source, err := m.FileReadWriter.ReadFile(path)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("file not found")
-	} else if err != nil {
-		return err
+	// Validate watch interval.
+	if m.WatchInterval <= 0 {
+		return fmt.Errorf("invalid -watch-interval value: %s", m.WatchInterval)
 	}
 
-	// Build function map.
-	funcMap := sprig.TxtFuncMap()
-	funcMap["pluralize"] = pluralize
+	// Validate concurrency.
+	if m.Parallel < 1 {
+		return fmt.Errorf("invalid -parallel value: %d", m.Parallel)
+	}
 
-	// Parse file into template.
-	tmpl, err := template.New("main").Funcs(funcMap).Parse(string(source))
-	if err != nil {
-		return err
+	// Validate color mode.
+	switch m.Color {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return fmt.Errorf("invalid -color value: %s", m.Color)
 	}
 
-	// Create a comment at the top if generating to a .go file.
-	var buf bytes.Buffer
-	if !m.NoHeader {
-		switch filepath.Ext(outputPath) {
-		case ".go":
-			fmt.Fprintln(&buf, "// Generated by tmpl")
-			fmt.Fprintln(&buf, "// https://github.com/benbjohnson/tmpl")
-			fmt.Fprintln(&buf, "//")
-			fmt.Fprintln(&buf, "// DO NOT EDIT!")
-			fmt.Fprintln(&buf, "// Source:", path)
-			fmt.Fprintln(&buf, "")
-		}
+	// Validate stdin data format.
+	switch *stdinFormat {
+	case DataFormatJSON, DataFormatYAML, DataFormatTOML:
+	default:
+		return fmt.Errorf("invalid -stdin-format value: %s", *stdinFormat)
 	}
 
-	// Execute template.
-	if err := tmpl.Execute(&buf, m.Data); err != nil {
-		return err
+	// Validate array merge strategy.
+	switch m.MergeStrategy {
+	case MergeReplace, MergeAppend, MergeUniqueAppend:
+	default:
+		return fmt.Errorf("invalid -data-merge-strategy value: %s", m.MergeStrategy)
 	}
 
-	// Format output if it's a Go file.
-	// If there is an error during formatting then simply output unformatted Go.
-	output := buf.Bytes()
-	switch filepath.Ext(outputPath) {
-	case ".go":
-		formatted, err := format.Source(output)
+	// Read data from the command line, files, stdin, or a command's output.
+	if len(data) > 0 && *dataCmd != "" {
+		return errors.New("cannot specify both -data and -data-cmd")
+	} else if m.JSONL && (len(data) > 0 || *dataCmd != "") {
+		return errors.New("cannot use -jsonl with -data or -data-cmd")
+	} else if *dataRelToTmpl {
+		// Read data relative to each template as it is processed.
+		if len(data) != 1 || !strings.HasPrefix(data[0], "@") {
+			return errors.New("-data-relative-to-template requires -data=@path")
+		}
+		m.TemplateDataPath = strings.TrimPrefix(data[0], "@")
+	} else if len(data) > 0 {
+		// Merge each data source over the previous ones.
+		for _, v := range data {
+			value, err := m.readDataFlag(v, baseDir, *stdinFormat)
+			if err != nil {
+				return err
+			}
+			m.Data = mergeData(m.Data, value, m.MergeStrategy)
+		}
+
+		// Merged data has no single unparsed form.
+		if len(data) > 1 {
+			m.raw = nil
+		}
+	} else if *dataCmd != "" {
+		b, err := m.runCommand(*dataCmd, nil)
 		if err != nil {
-			m.FileReadWriter.WriteFile(outputPath, output, fi.Mode())
+			return fmt.Errorf("data command: %s", err)
+		} else if m.Data, err = m.decodeData(DataFormatJSON, b, m.JSONC); err != nil {
 			return err
 		}
-		output = formatted
 	}
 
-	// Write buffer to file.
-	if err := m.FileReadWriter.WriteFile(outputPath, output, fi.Mode()); err != nil {
-		return err
+	// Load functions from plugins, overriding built-in functions.
+	for _, path := range m.Plugins {
+		if *expandEnv {
+			if path, err = m.expandEnv(path); err != nil {
+				return err
+			}
+		}
+		funcs, err := loadPlugin(path)
+		if err != nil {
+			return err
+		}
+		if m.Funcs == nil {
+			m.Funcs = make(template.FuncMap)
+		}
+		for name, fn := range funcs {
+			m.Funcs[name] = fn
+		}
+	}
+
+	// All arguments are considered paths to process, up to a "--" separator.
+	// Arguments after the separator are passed to templates. Template names
+	// are resolved against the template directory when processed instead.
+	m.Paths, m.Args = splitArgs(fs, args)
+	if m.TemplateDir != "" {
+		m.TemplateDir = resolvePath(baseDir, m.TemplateDir)
+	}
+	for i := range m.Paths {
+		if *expandEnv {
+			if m.Paths[i], err = m.expandEnv(m.Paths[i]); err != nil {
+				return err
+			}
+		}
+		if !m.isTemplateName(m.Paths[i]) {
+			m.Paths[i] = resolvePath(baseDir, m.Paths[i])
+		}
 	}
 
 	return nil
 }
 
-func pluralize(s string) string {
-	return english.PluralWord(2, s, "")
+// splitArgs splits the arguments remaining after fs parsed args into paths
+// and the extra arguments following a "--" separator. The flag package
+// removes a separator directly following the flags, in which case all
+// remaining arguments are extras.
+func splitArgs(fs *flag.FlagSet, args []string) (paths, extras []string) {
+	rest := fs.Args()
+	if n := len(args) - len(rest); n > 0 && endsWithTerminator(fs, args[:n]) {
+		return nil, rest
+	}
+	for i, arg := range rest {
+		if arg == "--" {
+			return rest[:i], rest[i+1:]
+		}
+	}
+	return rest, nil
 }
 
-// fileReadWriter implements Main.FileReadWriter.
-type fileReadWriter struct{}
+// expandEnv expands $VAR and ${VAR} in the path s. Returns an error if a
+// variable is unset or empty, rather than silently dropping it from the path.
+func (m *Main) expandEnv(s string) (string, error) {
+	var unset string
+	expanded := os.Expand(s, func(key string) string {
+		v := m.OS.Getenv(key)
+		if v == "" && unset == "" {
+			unset = key
+		}
+		return v
+	})
+	if unset != "" {
+		return "", fmt.Errorf("-expand-env: variable not set: %s", unset)
+	}
+	return expanded, nil
+}
 
-func (*fileReadWriter) ReadFile(filename string) ([]byte, error) {
-	return ioutil.ReadFile(filename)
+// endsWithTerminator returns true if the flags parsed by fs from args end
+// with a "--" terminator, rather than with a flag whose value is "--".
+func endsWithTerminator(fs *flag.FlagSet, args []string) bool {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return i == len(args)-1
+		}
+
+		// Skip the value of a flag that is not written as -name=value.
+		if f := fs.Lookup(strings.TrimLeft(args[i], "-")); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+			}
+		}
+	}
+	return false
 }
-func (*fileReadWriter) WriteFile(filename string, data []byte, perm os.FileMode) error {
-	return ioutil.WriteFile(filename, data, perm)
+
+// readDataFlag decodes a -data value, which is either inline JSON, a @-prefixed
+// path of a data file relative to baseDir, or "-" to read stdin in format.
+func (m *Main) readDataFlag(v, baseDir, format string) (interface{}, error) {
+	switch {
+	case v == "-":
+		b, err := ioutil.ReadAll(m.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return m.decodeData(format, b, m.JSONC)
+	case strings.HasPrefix(v, "@"):
+		path := resolvePath(baseDir, strings.TrimPrefix(v, "@"))
+		m.DataPaths = append(m.DataPaths, path)
+		return m.readDataFile(path)
+	default:
+		return m.decodeData(DataFormatJSON, []byte(v), m.JSONC)
+	}
+}
+
+// resolvePath returns path joined to baseDir if path is relative.
+func resolvePath(baseDir, path string) string {
+	if baseDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// isLocalPath returns true if path is relative and names a file within the
+// directory it is relative to, rather than the directory itself or a file
+// outside it.
+func isLocalPath(path string) bool {
+	path = filepath.Clean(path)
+	return !filepath.IsAbs(path) && path != "." && path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
+
+// readFlagValue returns v or, if v has an @-prefix, the contents of the file it names.
+func (m *Main) readFlagValue(v string) (string, error) {
+	if !strings.HasPrefix(v, "@") {
+		return v, nil
+	}
+	buf, err := m.FileReadWriter.ReadFile(strings.TrimPrefix(v, "@"))
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// Run executes the program.
+func (m *Main) Run() error {
+	// Verify we have either a template string, a data directory, or at least
+	// one path.
+	if m.DataDir != "" {
+		if m.TemplatePath == "" || m.OutDir == "" {
+			return errors.New("-template and -outdir required with -data-dir")
+		} else if m.TemplateString != "" || len(m.Paths) > 0 || m.OutputPath != "" || m.SplitBy != "" || m.DefineFiles || m.TemplateDataPath != "" {
+			return errors.New("cannot use -data-dir with paths, -template-string, -o, -split-by, -define-files, or -data-relative-to-template")
+		}
+	} else if m.TemplatePath != "" || (m.OutDir != "" && m.TemplateDir == "") {
+		return errors.New("-template and -outdir require -data-dir")
+	} else if m.TemplateString != "" && len(m.Paths) > 0 {
+		return errors.New("cannot specify both -template-string and paths")
+	} else if m.TemplateString == "" && len(m.Paths) == 0 {
+		return errors.New("path required")
+	}
+	if m.SplitBy != "" && m.SplitName == "" {
+		return errors.New("-split-name required with -split-by")
+	} else if m.SplitBy != "" && (m.OutputPath != "" || m.TemplateString != "") {
+		return errors.New("cannot use -split-by with -o or -template-string")
+	} else if m.DefineFiles && (m.SplitBy != "" || m.OutputPath != "" || m.TemplateString != "") {
+		return errors.New("cannot use -define-files with -split-by, -o, or -template-string")
+	}
+	if m.JSONL && (m.DataDir != "" || m.SplitBy != "" || m.DefineFiles || m.TemplateDataPath != "") {
+		return errors.New("cannot use -jsonl with -data-dir, -split-by, -define-files, or -data-relative-to-template")
+	}
+	if m.Foreach && (m.JSONL || m.SplitBy != "" || m.DefineFiles) {
+		return errors.New("cannot use -foreach with -jsonl, -split-by, or -define-files")
+	}
+	if m.Prune && m.ManifestPath == "" {
+		return errors.New("-prune requires -manifest")
+	}
+	if m.OutputDirTemp && m.comparing() {
+		return errors.New("cannot use -output-dir-temp with -verify or -diff")
+	}
+	if m.OutputDirTemp && m.ManifestPath != "" {
+		// The manifest would list temporary paths and pruning would remove
+		// the real outputs of the previous manifest.
+		return errors.New("cannot use -output-dir-temp with -manifest or -prune")
+	}
+	if m.OutputDirTemp && (m.Incremental || m.Since != "") {
+		// Outputs would be skipped based on the real outputs, leaving them
+		// missing from the temporary directory.
+		return errors.New("cannot use -output-dir-temp with -incremental or -since")
+	}
+	m.generated, m.written, m.outOfDate, m.tempDir, m.prelude = nil, nil, nil, "", nil
+	m.inputs, m.dependencies, m.changed, m.recorded, m.watched = nil, nil, nil, nil, nil
+	m.failures, m.warnings = 0, 0
+
+	// Parse prelude up front, with an empty template, so that its errors are
+	// reported before those of any template.
+	if m.PreludePath != "" {
+		if _, err := m.parse("", nil); err != nil {
+			return err
+		}
+	}
+
+	// Only parse templates, if requested.
+	if m.ValidateOnly {
+		return m.validateAll()
+	}
+
+	// Treat missing data as an empty map, if requested.
+	if m.AllowMissingData && m.Data == nil {
+		m.Data = map[string]interface{}{}
+	}
+
+	// Validate data before rendering, if a schema is specified.
+	// Per-template data, data directory files, and records are validated as
+	// they are read.
+	if m.SchemaPath != "" && m.TemplateDataPath == "" && m.DataDir == "" && !m.JSONL {
+		if err := m.validateSchema(m.unnestData(m.Data)); err != nil {
+			return err
+		}
+	}
+
+	// Create temporary output directory, if requested.
+	if m.OutputDirTemp {
+		dir, err := m.OS.TempDir("", "tmpl-")
+		if err != nil {
+			return err
+		}
+		m.tempDir = dir
+		fmt.Fprintln(m.Stdout, dir)
+	}
+
+	// Determine which files changed, if only changed templates are processed.
+	if m.Since != "" && !m.comparing() {
+		m.loadChangedFiles()
+	}
+
+	// Read the inputs of each output from the previous depfile so that they
+	// are checked, and kept, when outputs are not regenerated.
+	if m.DepfilePath != "" && (m.Incremental || m.Since != "") && !m.comparing() {
+		recorded, err := m.readDepfile()
+		if err != nil {
+			return err
+		}
+		m.recorded = recorded
+	}
+
+	if err := m.processAll(); err != nil {
+		return err
+	} else if m.failures > 0 {
+		return fmt.Errorf("failed with %s", english.Plural(m.failures, "error", ""))
+	}
+
+	// Report any files that differ from their rendered output.
+	if m.Verify && len(m.outOfDate) > 0 {
+		return fmt.Errorf("generated files are out of date:\n\t%s", strings.Join(m.outOfDate, "\n\t"))
+	}
+
+	// Remove files from the previous manifest that are no longer generated
+	// and write the manifest of generated files, if requested.
+	if m.ManifestPath != "" && !m.comparing() {
+		if m.Prune {
+			if err := m.prune(); err != nil {
+				return err
+			}
+		}
+		if err := m.writeManifest(); err != nil {
+			return err
+		}
+	}
+
+	// Write dependencies of generated files, if requested.
+	if m.DepfilePath != "" && !m.comparing() {
+		if err := m.writeDepfile(); err != nil {
+			return err
+		}
+	}
+
+	// Fail if any warnings were emitted and warnings are treated as errors.
+	if m.Werror && m.warnings > 0 {
+		return fmt.Errorf("%s treated as errors", english.Plural(m.warnings, "warning", ""))
+	}
+
+	// Run post-hook on the written files, if specified.
+	if m.PostHook != "" && len(m.written) > 0 {
+		if err := m.runPostHook(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPostHook runs PostHook with the paths written during the run on stdin,
+// one per line. The command's output is passed through to Stdout & Stderr.
+func (m *Main) runPostHook() error {
+	stdin := strings.NewReader(strings.Join(m.written, "\n") + "\n")
+	if err := m.CommandRunner.RunCommand(m.PostHook, stdin, m.Stdout, m.Stderr); err != nil {
+		return fmt.Errorf("post-hook %q: %s", m.PostHook, err)
+	}
+	return nil
+}
+
+// processAll processes the inline template, the data directory, and each
+// path. In JSONL mode, these are processed for each record instead.
+func (m *Main) processAll() error {
+	if m.JSONL {
+		return m.processJSONL()
+	}
+
+	// Process inline template, if specified.
+	if m.TemplateString != "" {
+		if err := m.aggregateError(m.processString()); err != nil {
+			return err
+		}
+	}
+
+	// Process each data file in the data directory, if specified.
+	if m.DataDir != "" {
+		if err := m.processDataDir(); err != nil {
+			return err
+		}
+	}
+
+	// Create the output directory for template names, if specified.
+	if m.TemplateDir != "" && m.OutDir != "" {
+		if err := m.OS.MkdirAll(m.OutDir, 0777); err != nil {
+			return err
+		}
+	}
+
+	// Process each path.
+	if m.Parallel > 1 {
+		return m.processParallel()
+	}
+	for _, path := range m.Paths {
+		err := m.process(path)
+		m.addWatched(path, m.inputs)
+		if err := m.aggregateError(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregateError returns err unless errors are being aggregated, in which
+// case a non-nil err is written to Stderr and counted instead.
+func (m *Main) aggregateError(err error) error {
+	if err == nil || m.OnError != OnErrorAggregate {
+		return err
+	}
+	m.PrintError(err)
+	m.failures++
+	return nil
+}
+
+// process reads a template file from path, processes it, and writes it to its generated path.
+func (m *Main) process(path string) error {
+	path, outputPath := m.templatePaths(path)
+	m.inputs = nil
+	r, perm, err := m.readTemplate(path)
+	if err != nil || r == nil {
+		return err
+	}
+
+	// Read data relative to the template, if requested.
+	data := m.Data
+	if m.TemplateDataPath != "" {
+		if data, err = m.readTemplateData(path); err != nil {
+			return err
+		}
+	}
+
+	// Generate a separate output for each defined file or item, if requested.
+	if m.DefineFiles {
+		return m.generateDefines(r, data, perm)
+	} else if m.SplitBy != "" {
+		return m.generateSplit(r, data, perm)
+	}
+
+	// Skip outputs that are up to date, if building incrementally.
+	if m.Incremental && !m.comparing() {
+		if ok, err := m.isFresh(path, outputPath); err != nil {
+			return err
+		} else if ok {
+			return m.skipFresh(outputPath)
+		}
+	}
+
+	// Skip outputs whose inputs are unchanged since the git ref, if requested.
+	if m.changed != nil {
+		if ok, err := m.isChanged(path, outputPath); err != nil {
+			return err
+		} else if !ok {
+			return m.skipFresh(outputPath)
+		}
+	}
+
+	return m.generate(r, outputPath, data, perm)
+}
+
+// readTemplate reads & parses the template file at path and returns a
+// Renderer for it with the file's mode. Returns a nil Renderer if the file is
+// missing and missing files are skipped.
+func (m *Main) readTemplate(path string) (*Renderer, os.FileMode, error) {
+	// Validate that we have a prefix we can strip off for the generated path.
+	if !strings.HasSuffix(path, Extension) {
+		return nil, 0, fmt.Errorf("path must have %s extension: %s", Extension, path)
+	}
+
+	// Stat the file to retrieve the mode.
+	fi, err := m.OS.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, 0, m.missingFile(path)
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	// Read in template file.
+	source, err := m.FileReadWriter.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, 0, m.missingFile(path)
+	} else if err != nil {
+		return nil, 0, err
+	}
+	m.addInput(path)
+
+	r, err := m.NewRenderer(path, source)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, fi.Mode(), nil
+}
+
+// isTemplateName returns true if path is the name of a template in
+// TemplateDir. This is the case for paths without a directory when
+// TemplateDir is set.
+func (m *Main) isTemplateName(path string) bool {
+	return m.TemplateDir != "" && !strings.ContainsRune(path, '/') && !strings.ContainsRune(path, filepath.Separator)
+}
+
+// templatePaths returns the template file path and the generated path of a
+// path to process. A template name is resolved against TemplateDir, with the
+// template extension added if missing, and generates to OutDir.
+func (m *Main) templatePaths(path string) (string, string) {
+	if !m.isTemplateName(path) {
+		return path, m.outputPath(path)
+	}
+
+	name := path
+	if !strings.HasSuffix(name, Extension) {
+		name += Extension
+	}
+	return filepath.Join(m.TemplateDir, name), m.outputPath(filepath.Join(m.OutDir, name))
+}
+
+// outputPath returns the path that the template at path is generated to.
+//
+// By default, this is the template path with the template extension removed.
+// If a rule in OutExts matches the path then its extension is replaced
+// instead. If OutSuffix is set then it is inserted before the last remaining
+// extension, if any. For example, "a.b.js.tmpl" with a suffix of ".min"
+// generates to "a.b.min.js".
+func (m *Main) outputPath(path string) string {
+	if m.OutputPath != "" {
+		return m.OutputPath
+	}
+
+	outputPath := strings.TrimSuffix(path, Extension)
+	for _, rule := range m.OutExts {
+		if strings.HasSuffix(path, rule.From) {
+			outputPath = strings.TrimSuffix(path, rule.From) + rule.To
+			break
+		}
+	}
+	if m.OutSuffix != "" {
+		ext := filepath.Ext(outputPath)
+		outputPath = strings.TrimSuffix(outputPath, ext) + m.OutSuffix + ext
+	}
+	return outputPath
+}
+
+// processString processes TemplateString and writes it to OutputPath, if
+// specified, or to Stdout otherwise.
+func (m *Main) processString() error {
+	m.inputs = nil
+	r, err := m.NewRenderer(TemplateStringName, []byte(m.TemplateString))
+	if err != nil {
+		return err
+	}
+
+	if m.OutputPath != "" {
+		return m.generate(r, m.OutputPath, m.Data, 0666)
+	}
+	return m.writeStdout(r, m.Data)
+}
+
+// RenderReader reads template source from r, executes it against data, and
+// returns the output. The name is used as with NewRenderer. To render the
+// same template repeatedly, use NewRenderer to avoid reparsing it each time.
+func (m *Main) RenderReader(r io.Reader, name string, data interface{}) ([]byte, error) {
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer, err := m.NewRenderer(name, source)
+	if err != nil {
+		return nil, err
+	}
+	return renderer.Execute(data)
+}
+
+// writeStdout executes r against data and writes the output to Stdout.
+// Nothing is written if the template calls skipFile before writing output.
+func (m *Main) writeStdout(r *Renderer, data interface{}) error {
+	// Execute directly to stdout if no post-processing is required.
+	if m.canStream("") {
+		if err := m.execute(m.Stdout, r.tmpl, data); err != nil && !isSkipFile(err) {
+			return err
+		}
+		return nil
+	}
+
+	output, err := r.render("", data)
+	if isSkipFile(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if output, err = m.encode(r.name, output); err != nil {
+		return err
+	}
+	_, err = m.Stdout.Write(output)
+	return err
+}
+
+// tempOutputPath returns the path within the temporary directory that
+// outputPath is written to. Relative paths are mirrored from the working
+// directory and absolute paths from the root, so paths outside the working
+// directory cannot be mirrored without escaping the temporary directory.
+func (m *Main) tempOutputPath(outputPath string) (string, error) {
+	rel := filepath.Clean(outputPath)
+	if !filepath.IsAbs(rel) && (rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+		return "", fmt.Errorf("-output-dir-temp: output path is outside the working directory: %s", outputPath)
+	}
+	return filepath.Join(m.tempDir, rel), nil
+}
+
+// generate executes r against data and writes the output to outputPath.
+// Nothing is written if the template calls skipFile.
+func (m *Main) generate(r *Renderer, outputPath string, data interface{}, perm os.FileMode) (err error) {
+	path := r.name
+
+	// Never overwrite the template itself.
+	if filepath.Clean(outputPath) == filepath.Clean(path) {
+		return fmt.Errorf("output path is the same as template path: %s", path)
+	}
+
+	// Skipping an output is not an error. This runs after any other deferred
+	// function so they see the skip as a failure and don't report the output.
+	defer func() {
+		if isSkipFile(err) {
+			err = nil
+		}
+	}()
+
+	// Redirect output into the temporary directory, if requested.
+	if m.tempDir != "" {
+		if outputPath, err = m.tempOutputPath(outputPath); err != nil {
+			return err
+		}
+		if err := m.OS.MkdirAll(filepath.Dir(outputPath), 0777); err != nil {
+			return err
+		}
+		defer func() {
+			if err == nil {
+				fmt.Fprintf(m.Stdout, "%s\t%s\n", path, outputPath)
+			}
+		}()
+	}
+
+	// Override the mode of the output, if a rule matches. Writing a file only
+	// sets the mode of new files so the mode is also set once written.
+	if rule, ok := m.permRule(outputPath); ok {
+		perm = rule.Perm
+		if m.Output == nil && !m.comparing() {
+			defer func() {
+				if err == nil {
+					err = m.OS.Chmod(outputPath, perm)
+				}
+			}()
+		}
+	}
+
+	// Write directly to the output file if no post-processing is required.
+	if m.canStream(outputPath) {
+		return m.stream(outputPath, r.tmpl, data, perm)
+	}
+
+	output, err := r.render(outputPath, data)
+	if err != nil {
+		return err
+	}
+
+	// Ensure we are not overwriting a file that was not generated by tmpl.
+	if m.OverwriteProtect {
+		if err := m.checkOverwrite(outputPath); err != nil {
+			return err
+		}
+	}
+
+	// Format output if it's a Go file.
+	// If there is an error during formatting then simply output unformatted Go.
+	switch filepath.Ext(outputPath) {
+	case ".go":
+		if err := m.checkUTF8(outputPath, output); err != nil {
+			return err
+		}
+
+		formatted, err := m.formatGo(outputPath, output)
+		if err != nil {
+			if !m.comparing() {
+				m.writeOutput(outputPath, formatted, perm)
+			}
+			return err
+		}
+		output = formatted
+
+		// Ensure the output is a complete Go file, if requested, as formatting
+		// also accepts partial files such as a list of declarations.
+		if m.CheckGoCompiles {
+			if _, err := parser.ParseFile(token.NewFileSet(), outputPath, output, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Run external formatter, if one is configured for the extension.
+	if command, ok := m.Formatters[filepath.Ext(outputPath)]; ok {
+		formatted, err := m.runCommand(command, output)
+		if err != nil {
+			return fmt.Errorf("%s: formatter %q: %s", outputPath, command, err)
+		}
+		output = formatted
+	}
+
+	// Transcode output from UTF-8, if another encoding is requested.
+	if output, err = m.encode(outputPath, output); err != nil {
+		return err
+	}
+
+	// Compare against the existing file instead of writing in verify or
+	// diff mode.
+	if m.comparing() {
+		if ok, err := m.isUpToDate(outputPath, output); err != nil {
+			return err
+		} else if !ok {
+			m.outOfDate = append(m.outOfDate, outputPath)
+			if m.Diff {
+				return m.printDiff(outputPath, output)
+			}
+		}
+		return nil
+	}
+
+	// Ensure a runaway template doesn't write an excessively large file.
+	if m.MaxSize > 0 && int64(len(output)) > m.MaxSize {
+		return fmt.Errorf("%s: output size of %d bytes exceeds -max-size of %d bytes", outputPath, len(output), m.MaxSize)
+	}
+
+	// Back up the previous output, if requested.
+	if m.Backup {
+		if err := m.backup(outputPath); err != nil {
+			return err
+		}
+	}
+
+	// Write buffer to file.
+	if err := m.writeOutput(outputPath, output, perm); err != nil {
+		return err
+	}
+	m.generated = append(m.generated, newManifestFile(outputPath, output))
+	m.written = append(m.written, outputPath)
+	m.addDependency(outputPath)
+
+	// Write generated notice to a sibling file, if requested.
+	if m.noticeStyle(outputPath) == NoticeFile {
+		if err := m.writeNoticeFile(path, outputPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOutput writes the generated output to path with Output, if set, or
+// to the file system otherwise.
+func (m *Main) writeOutput(path string, data []byte, perm os.FileMode) error {
+	if m.Output != nil {
+		return m.Output(path, data, perm)
+	}
+	return m.FileReadWriter.WriteFile(path, data, perm)
+}
+
+// canStream returns true if output to outputPath requires no post-processing
+// and therefore can be written as the template executes, without buffering.
+//
+// Buffering is still required for Go files, which are formatted, for files
+// with a header, a generated notice, or an external formatter, for
+// encodings other than UTF-8, and when collapsing blank lines, writing line
+// directives, verifying or diffing, enforcing a maximum size or a timeout,
+// writing a manifest or depfile, running a post-hook, or writing to a custom
+// Output.
+func (m *Main) canStream(outputPath string) bool {
+	ext := filepath.Ext(outputPath)
+	return m.Output == nil &&
+		ext != ".go" &&
+		m.headerCommentPrefix(outputPath) == "" &&
+		m.noticeStyle(outputPath) == "" &&
+		m.isUTF8() &&
+		m.Formatters[ext] == "" &&
+		!m.CollapseBlankLines &&
+		!m.LineDirectives &&
+		!m.comparing() &&
+		m.MaxSize == 0 &&
+		m.Timeout == 0 &&
+		m.ManifestPath == "" &&
+		m.DepfilePath == "" &&
+		m.PostHook == ""
+}
+
+// stream executes tmpl against data directly into the file at outputPath.
+// The file is only replaced once execution succeeds. If execution fails, or
+// the template calls skipFile, the existing file is left unchanged.
+func (m *Main) stream(outputPath string, tmpl *template.Template, data interface{}, perm os.FileMode) error {
+	// Ensure we are not overwriting a file that was not generated by tmpl.
+	if m.OverwriteProtect {
+		if err := m.checkOverwrite(outputPath); err != nil {
+			return err
+		}
+	}
+
+	return m.FileReadWriter.WriteFileFunc(outputPath, perm, func(w io.Writer) error {
+		// Buffer writes as templates perform many small writes.
+		bw := bufio.NewWriter(w)
+		if err := m.execute(bw, tmpl, data); err != nil {
+			return err
+		} else if err := bw.Flush(); err != nil {
+			return err
+		}
+
+		// Back up the existing file only once it is going to be replaced.
+		if m.Backup {
+			return m.backup(outputPath)
+		}
+		return nil
+	})
+}
+
+// headerCommentPrefix returns the line comment prefix used to write the
+// generated file header to outputPath. Returns a blank string if no header
+// should be written.
+func (m *Main) headerCommentPrefix(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	if m.NoHeader || (ext != ".go" && !m.HeaderAll) {
+		return ""
+	} else if prefix, ok := m.HeaderComments[ext]; ok {
+		return prefix
+	}
+	return HeaderCommentPrefixes[ext]
+}
+
+// parse validates and parses the template source from path.
+func (m *Main) parse(path string, source []byte) (*template.Template, error) {
+	// Ensure template is valid UTF-8.
+	if err := m.checkUTF8(path, source); err != nil {
+		return nil, err
+	}
+
+	// Disable whitespace trimming within fenced blocks, if requested.
+	if len(m.VerbatimFences) > 0 {
+		source = ignoreTrimMarkersInFences(source, m.VerbatimFences)
+	}
+
+	tmpl := template.New(path)
+	tmpl.Funcs(m.templateFuncs(tmpl))
+	if m.StrictMissingData {
+		tmpl.Option("missingkey=error")
+	}
+
+	// Parse prelude into the template set first so that its defines can be
+	// used, or overridden, by the template.
+	if m.PreludePath != "" {
+		if err := m.parsePrelude(tmpl); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse file into template.
+	if _, err := tmpl.Parse(string(source)); err != nil {
+		return nil, err
+	}
+
+	// Mark source lines in Go output, if line directives are requested.
+	if m.LineDirectives && m.generatesGo(path) {
+		annotateLines(tmpl)
+	}
+	return tmpl, nil
+}
+
+// parsePrelude parses the prelude at PreludePath into the set associated
+// with tmpl. The prelude is read on first use.
+func (m *Main) parsePrelude(tmpl *template.Template) error {
+	if m.prelude == nil {
+		buf, err := m.FileReadWriter.ReadFile(m.PreludePath)
+		if err != nil {
+			return fmt.Errorf("prelude: %s", err)
+		} else if err := m.checkUTF8(m.PreludePath, buf); err != nil {
+			return fmt.Errorf("prelude: %s", err)
+		}
+		if len(m.VerbatimFences) > 0 {
+			buf = ignoreTrimMarkersInFences(buf, m.VerbatimFences)
+		}
+		m.prelude = buf
+	}
+
+	if _, err := tmpl.New(m.PreludePath).Parse(string(m.prelude)); err != nil {
+		return fmt.Errorf("prelude: %s", err)
+	}
+	return nil
+}
+
+// templateFuncs returns the functions available to tmpl, including those that
+// execute other templates in its set.
+func (m *Main) templateFuncs(tmpl *template.Template) template.FuncMap {
+	funcMap := m.funcMap()
+	funcMap["tpl"] = executeTemplateFunc(tmpl)
+	if m.LineDirectives {
+		// Line markers are only meaningful in the output of the template.
+		tpl := funcMap["tpl"].(func(string, interface{}) (string, error))
+		funcMap["tpl"] = func(name string, data interface{}) (string, error) {
+			s, err := tpl(name, data)
+			output, _ := stripLineMarkers([]byte(s))
+			return string(output), err
+		}
+	}
+	funcMap["capture"] = funcMap["tpl"]
+	return funcMap
+}
+
+// execute executes tmpl against data to w, wrapped in Prefix & Suffix.
+// If Foreach is set, tmpl is executed against each element of data instead.
+//
+// If Timeout is set then execution is abandoned with an error once it
+// elapses. As templates cannot be interrupted, execution continues in the
+// background but its output, inputs, and warnings are discarded.
+func (m *Main) execute(w io.Writer, tmpl *template.Template, data interface{}) error {
+	if m.Timeout <= 0 {
+		return m.executeOnce(w, tmpl, data)
+	}
+
+	// Execute a copy of tmpl bound to a copy of m so that an abandoned
+	// execution cannot modify m while later templates are processed.
+	// Colorization is resolved before its warnings are buffered.
+	var stderr bytes.Buffer
+	d := *m
+	d.inputs, d.warnings, d.Stderr = append([]string(nil), m.inputs...), 0, &stderr
+	if d.Color = ColorNever; m.useColor() {
+		d.Color = ColorAlways
+	}
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	clone.Funcs(d.templateFuncs(clone))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
+	defer cancel()
+
+	// Execute into a separate buffer so an abandoned execution cannot write
+	// to w. Output is only copied to w if execution succeeds.
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- d.executeOnce(&buf, clone, data) }()
+
+	select {
+	case err := <-done:
+		// Merge the state of the completed execution back into m.
+		m.inputs, m.keyOrders = d.inputs, d.keyOrders
+		m.warnings += d.warnings
+		if _, err := m.Stderr.Write(stderr.Bytes()); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%s: execution timed out after %s", tmpl.Name(), m.Timeout)
+	}
+}
+
+// executeOnce executes tmpl against data to w, wrapped in Prefix & Suffix.
+func (m *Main) executeOnce(w io.Writer, tmpl *template.Template, data interface{}) error {
+	if _, err := io.WriteString(w, m.Prefix); err != nil {
+		return err
+	} else if m.Foreach {
+		if err := m.executeEach(w, tmpl, data); err != nil {
+			return err
+		}
+	} else if err := tmpl.Execute(w, data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, m.Suffix)
+	return err
+}
+
+// executeEach executes tmpl against each element of the array data, writing
+// RecordSeparator between elements. Returns an error if data is not an array.
+func (m *Main) executeEach(w io.Writer, tmpl *template.Template, data interface{}) error {
+	items := toList(data)
+	if items == nil {
+		return fmt.Errorf("-foreach requires array data, got %T", data)
+	}
+
+	for i, item := range items {
+		if i > 0 {
+			if _, err := io.WriteString(w, m.RecordSeparator); err != nil {
+				return err
+			}
+		}
+		if err := tmpl.Execute(w, item); isSkipFile(err) {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("element %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// checkUTF8 reports the position of the first invalid UTF-8 sequence in b.
+// This is a warning unless StrictUTF8 is set, in which case an error is returned.
+func (m *Main) checkUTF8(name string, b []byte) error {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			if m.StrictUTF8 {
+				return fmt.Errorf("%s: invalid UTF-8 at byte offset %d", name, i)
+			}
+			m.warnf("%s: invalid UTF-8 at byte offset %d", name, i)
+			return nil
+		}
+		i += size
+	}
+	return nil
+}
+
+// collapseBlankLines replaces consecutive blank lines with a single empty
+// line. Lines containing only whitespace are considered blank. The line
+// ending of the kept line, "\n" or "\r\n", is preserved.
+func collapseBlankLines(b []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	other := lines[:0]
+	var prevBlank bool
+	for i, line := range lines {
+		blank := len(bytes.TrimSpace(line)) == 0
+		if blank {
+			// Skip if the previous line was blank. The final element is
+			// always kept so that a trailing newline is preserved.
+			if prevBlank && i < len(lines)-1 {
+				continue
+			}
+
+			if bytes.HasSuffix(line, []byte("\r")) {
+				line = []byte("\r")
+			} else {
+				line = nil
+			}
+		}
+		other, prevBlank = append(other, line), blank
+	}
+	return bytes.Join(other, []byte("\n"))
+}
+
+// Whitespace trim markers, which are followed or preceded by a space, tab,
+// carriage return, or the end of the line.
+var (
+	leftTrimMarkerRegex  = regexp.MustCompile(`\{\{-([ \t\r]|$)`)
+	rightTrimMarkerRegex = regexp.MustCompile(`(^|[ \t\r])-\}\}`)
+)
+
+// ignoreTrimMarkersInFences removes the "-" of the "{{- " and " -}}"
+// whitespace trim markers on lines within fenced blocks.
+//
+// A block is opened by a line that starts with one of fences, after up to
+// three spaces of indentation, and is closed by the next line that starts
+// with the same fence. The opening & closing lines are within the block.
+func ignoreTrimMarkersInFences(b []byte, fences []string) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	var open string
+	for i, line := range lines {
+		// Determine if the line opens or closes a block.
+		fence := lineFence(line, fences)
+		inside := open != "" || fence != ""
+		if open == "" {
+			open = fence
+		} else if fence == open {
+			open = ""
+		}
+
+		if inside {
+			line = leftTrimMarkerRegex.ReplaceAll(line, []byte("{{$1"))
+			lines[i] = rightTrimMarkerRegex.ReplaceAll(line, []byte("$1}}"))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// lineFence returns the fence that line starts with, if any.
+func lineFence(line []byte, fences []string) string {
+	n := 0
+	for n < 3 && n < len(line) && line[n] == ' ' {
+		n++
+	}
+	for _, fence := range fences {
+		if bytes.HasPrefix(line[n:], []byte(fence)) {
+			return fence
+		}
+	}
+	return ""
+}
+
+// comparing returns true if outputs are compared against existing files
+// instead of being written, as in verify & diff modes.
+func (m *Main) comparing() bool {
+	return m.Verify || m.Diff
+}
+
+// isUpToDate returns true if the file at path exists and its contents match output.
+func (m *Main) isUpToDate(path string, output []byte) (bool, error) {
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(buf, output), nil
+}
+
+// checkOverwrite returns an error if path exists and does not have the
+// generated file marker in its header line.
+func (m *Main) checkOverwrite(path string) error {
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	buf = m.decode(buf)
+	if m.isGenerated(buf) {
+		return nil
+	}
+	if ok, err := m.hasNotice(path, buf); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("refusing to overwrite file not generated by tmpl: %s", path)
+	}
+	return nil
+}
+
+// headerLine returns the first line of the generated file header.
+func (m *Main) headerLine() string {
+	if m.HeaderMarker != "" {
+		return m.HeaderMarker + " by tmpl"
+	}
+	return DefaultHeaderMarker + " by tmpl"
+}
+
+// isGenerated returns true if the header line of buf marks it as generated.
+// The header line is the first line, or the second after an interpreter line.
+// Without a custom marker, a standard Go "Code generated" header is also
+// accepted.
+func (m *Main) isGenerated(buf []byte) bool {
+	if bytes.HasPrefix(buf, []byte("#!")) {
+		if i := bytes.IndexByte(buf, '\n'); i != -1 {
+			buf = buf[i+1:]
+		}
+	}
+	if i := bytes.IndexByte(buf, '\n'); i != -1 {
+		buf = buf[:i]
+	}
+	if m.HeaderMarker != "" {
+		return bytes.Contains(buf, []byte(m.HeaderMarker))
+	}
+	return bytes.Contains(buf, []byte(m.headerLine())) || bytes.Contains(buf, []byte(GeneratedMarker))
+}
+
+// backup copies the file at path to path with BackupSuffix appended.
+// Does nothing if the file does not exist.
+func (m *Main) backup(path string) error {
+	buf, err := m.FileReadWriter.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return m.writeOutput(path+m.BackupSuffix, buf, 0666)
+}
+
+// runCommand executes command with stdin and returns its standard output.
+// If the command fails then its standard error is included in the error.
+func (m *Main) runCommand(command string, stdin []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	if err := m.CommandRunner.RunCommand(command, bytes.NewReader(stdin), &stdout, &stderr); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseExtensionMap parses a comma-separated list of ext=value pairs for
+// the flag with the given name.
+func parseExtensionMap(name, s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		ext, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			ext, value = pair[:i], pair[i+1:]
+		}
+		if !strings.HasPrefix(ext, ".") || value == "" {
+			return nil, fmt.Errorf("invalid -%s value: %s", name, pair)
+		}
+		m[ext] = value
+	}
+	return m, nil
+}
+
+// parsePermRules parses a comma-separated list of EXT=MODE rules, where MODE
+// is an octal file mode such as 0755.
+func parsePermRules(s string) ([]PermRule, error) {
+	var rules []PermRule
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i == -1 || !strings.HasPrefix(pair, ".") {
+			return nil, fmt.Errorf("invalid -perm-map value: %s", pair)
+		}
+		perm, err := strconv.ParseUint(pair[i+1:], 8, 32)
+		if err != nil || perm > 0777 {
+			return nil, fmt.Errorf("invalid -perm-map mode: %s", pair)
+		}
+		rules = append(rules, PermRule{Ext: pair[:i], Perm: os.FileMode(perm)})
+	}
+	return rules, nil
+}
+
+// permRule returns the first rule in Perms matching outputPath, if any.
+func (m *Main) permRule(outputPath string) (PermRule, bool) {
+	for _, rule := range m.Perms {
+		if strings.HasSuffix(outputPath, rule.Ext) {
+			return rule, true
+		}
+	}
+	return PermRule{}, false
+}
+
+// parseOutExts parses a comma-separated list of FROM=TO output extension
+// rules. Each FROM must end with the template extension.
+func parseOutExts(s string) ([]OutExt, error) {
+	var rules []OutExt
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i == -1 || !strings.HasPrefix(pair, ".") || !strings.HasSuffix(pair[:i], Extension) {
+			return nil, fmt.Errorf("invalid -out-ext value: %s", pair)
+		}
+		rules = append(rules, OutExt{From: pair[:i], To: pair[i+1:]})
+	}
+	return rules, nil
+}
+
+// missingFile returns an error for a missing path unless missing files are
+// configured to be skipped, in which case a warning is written instead.
+func (m *Main) missingFile(path string) error {
+	if m.OnMissingFile == MissingFileSkip {
+		m.warnf("skipping missing file: %s", path)
+		return nil
+	}
+	return fmt.Errorf("file not found")
+}
+
+// fileReadWriter implements Main.FileReadWriter.
+type fileReadWriter struct{}
+
+func (*fileReadWriter) ReadFile(filename string) ([]byte, error) {
+	return ioutil.ReadFile(filename)
+}
+func (*fileReadWriter) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(filename, data, perm)
+}
+
+// WriteFileFunc writes the output of fn to a temporary file in the same
+// directory as filename and renames it over filename if fn succeeds. As with
+// WriteFile, a symbolic link at filename is followed and the mode of an
+// existing file is kept.
+func (*fileReadWriter) WriteFileFunc(filename string, perm os.FileMode, fn func(w io.Writer) error) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	fi, err := os.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	} else if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if fi != nil {
+		if err := os.Chmod(tmpPath, fi.Mode().Perm()); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	return os.Rename(tmpPath, filename)
+}
+
+// commandRunner implements Main.CommandRunner.
+type commandRunner struct{}
+
+// RunCommand executes command using the system shell.
+func (*commandRunner) RunCommand(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return cmd.Run()
+}
+
+// stringSlice is a flag value that accumulates repeated flags.
+type stringSlice []string
+
+func (a *stringSlice) String() string { return strings.Join(*a, ",") }
+
+func (a *stringSlice) Set(v string) error {
+	*a = append(*a, v)
+	return nil
 }
 
 // mainOS implements Main.OS.
 type mainOS struct{}
 
-func (*mainOS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+func (*mainOS) Stat(name string) (os.FileInfo, error)         { return os.Stat(name) }
+func (*mainOS) Getenv(key string) string                      { return os.Getenv(key) }
+func (*mainOS) Executable() (string, error)                   { return os.Executable() }
+func (*mainOS) MkdirAll(path string, perm os.FileMode) error  { return os.MkdirAll(path, perm) }
+func (*mainOS) Chmod(name string, mode os.FileMode) error     { return os.Chmod(name, mode) }
+func (*mainOS) Remove(name string) error                      { return os.Remove(name) }
+func (*mainOS) TempDir(dir, pattern string) (string, error)   { return ioutil.TempDir(dir, pattern) }
+func (*mainOS) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
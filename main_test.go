@@ -2,11 +2,19 @@ package main_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 
 	main "github.com/benbjohnson/tmpl"
@@ -49,73 +57,3416 @@ func TestMain_ParseFlags_Data_File(t *testing.T) {
 	}
 }
 
+// Ensure repeated data flags are deeply merged, replacing arrays by default.
+func TestMain_ParseFlags_Data_Merge(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("db:\n  host: prod\ntags: [b, c]\n"), nil
+	}
+
+	if err := m.ParseFlags([]string{
+		"-data", `{"db":{"host":"localhost","port":5432},"tags":["a","b"],"name":"x"}`,
+		"-data", "@prod.yaml",
+	}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{
+		"db":   map[string]interface{}{"host": "prod", "port": float64(5432)},
+		"tags": []interface{}{"b", "c"},
+		"name": "x",
+	}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	} else if !reflect.DeepEqual(m.DataPaths, []string{"prod.yaml"}) {
+		t.Fatalf("unexpected data paths: %#v", m.DataPaths)
+	}
+}
+
+// Ensure arrays are combined according to the merge strategy.
+func TestMain_ParseFlags_Data_MergeStrategy(t *testing.T) {
+	for _, tt := range []struct {
+		strategy string
+		tags     []interface{}
+	}{
+		{"replace", []interface{}{"b", float64(3)}},
+		{"append", []interface{}{"a", "b", float64(3), "b", float64(3)}},
+		{"unique-append", []interface{}{"a", "b", float64(3)}},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{
+			"-data-merge-strategy", tt.strategy,
+			"-data", `{"x":{"tags":["a","b",3]}}`,
+			"-data", `{"x":{"tags":["b",3]}}`,
+		}); err != nil {
+			t.Fatalf("%s: %s", tt.strategy, err)
+		} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"x": map[string]interface{}{"tags": tt.tags}}) {
+			t.Fatalf("%s: unexpected data: %#v", tt.strategy, m.Data)
+		}
+	}
+}
+
+// Ensure values of different types are replaced rather than merged.
+func TestMain_ParseFlags_Data_Merge_Replace(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{
+		"-data-merge-strategy", "append",
+		"-data", `{"a":["x"],"b":{"c":1},"d":1}`,
+		"-data", `{"a":{"y":1},"b":["z"],"d":null}`,
+	}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{
+		"a": map[string]interface{}{"y": float64(1)},
+		"b": []interface{}{"z"},
+		"d": nil,
+	}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure an invalid merge strategy returns an error.
+func TestMain_ParseFlags_Data_MergeStrategy_Invalid(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data-merge-strategy", "union"}); err == nil || err.Error() != `invalid -data-merge-strategy value: union` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure environment variables in paths are expanded when requested.
+func TestMain_ParseFlags_ExpandEnv(t *testing.T) {
+	m := NewMain()
+	m.OS.GetenvFn = func(key string) string {
+		if key == "CONFIG_DIR" {
+			return "/etc/app"
+		}
+		return ""
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename != "/etc/app/data.json" {
+			t.Fatalf("unexpected filename: %s", filename)
+		}
+		return []byte(`{"foo":"bar"}`), nil
+	}
+
+	if err := m.ParseFlags([]string{"-expand-env", "-data", "@$CONFIG_DIR/data.json", "-o", "${CONFIG_DIR}/out.go", "-depfile", "$CONFIG_DIR/deps.d", "-allow-read", "$CONFIG_DIR/secrets", "${CONFIG_DIR}/a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"foo": "bar"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	} else if m.OutputPath != "/etc/app/out.go" {
+		t.Fatalf("unexpected output path: %s", m.OutputPath)
+	} else if m.DepfilePath != "/etc/app/deps.d" {
+		t.Fatalf("unexpected depfile path: %s", m.DepfilePath)
+	} else if !reflect.DeepEqual(m.AllowedReadDirs, []string{"/etc/app/secrets"}) {
+		t.Fatalf("unexpected allowed read dirs: %#v", m.AllowedReadDirs)
+	} else if !reflect.DeepEqual(m.Paths, []string{"/etc/app/a.tmpl"}) {
+		t.Fatalf("unexpected paths: %#v", m.Paths)
+	}
+}
+
+// Ensure unset environment variables in paths are an error.
+func TestMain_ParseFlags_ExpandEnv_ErrUnset(t *testing.T) {
+	for _, args := range [][]string{
+		{"-expand-env", "-o", "${OUT}out.go", "a.tmpl"},
+		{"-expand-env", "$OUT/a.tmpl"},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(args); err == nil || err.Error() != `-expand-env: variable not set: OUT` {
+			t.Fatalf("%v: unexpected error: %v", args, err)
+		}
+	}
+}
+
+// Ensure paths and inline data are not expanded by default.
+func TestMain_ParseFlags_ExpandEnv_Disabled(t *testing.T) {
+	m := NewMain()
+	m.OS.GetenvFn = func(key string) string {
+		t.Fatalf("unexpected getenv: %s", key)
+		return ""
+	}
+	if err := m.ParseFlags([]string{"-data", `{"price":"$5"}`, "$HOME/a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"price": "$5"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	} else if !reflect.DeepEqual(m.Paths, []string{"$HOME/a.tmpl"}) {
+		t.Fatalf("unexpected paths: %#v", m.Paths)
+	}
+
+	m = NewMain()
+	if err := m.ParseFlags([]string{"-expand-env", "-data", `{"price":"$5"}`}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"price": "$5"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure data can be nested under a root key.
+func TestMain_ParseFlags_DataKey(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data-key", "Values", "-data", `{"foo":"bar"}`}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"Values": map[string]interface{}{"foo": "bar"}}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure data files can be read relative to each template.
+func TestMain_Run_DataRelativeToTemplate(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data-relative-to-template", "-data", "@data.json", "a/x.tmpl", "b/x.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a/data.json":
+			return []byte(`"A"`), nil
+		case "b/data.json":
+			return []byte(`"B"`), nil
+		default:
+			return []byte(`{{.}}`), nil
+		}
+	}
+
+	outputs := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(outputs, map[string]string{"a/x": "A", "b/x": "B"}) {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	}
+}
+
+// Ensure template-relative data requires a data file.
+func TestMain_ParseFlags_DataRelativeToTemplate_ErrNoFile(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data-relative-to-template", "-data", `{}`}); err == nil || err.Error() != `-data-relative-to-template requires -data=@path` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure data files are decoded based on their extension.
+func TestMain_ParseFlags_Data_File_Format(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("foo: bar\n"), nil
+	}
+
+	if err := m.ParseFlags([]string{"-data", `@data.yml`}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"foo": "bar"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure the format of data files with unknown extensions is detected from
+// their contents.
+func TestMain_ParseFlags_Data_File_SniffFormat(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		data    interface{}
+	}{
+		{"{\"foo\": \"bar\"}", map[string]interface{}{"foo": "bar"}},
+		{"\n  [1, 2]\n", []interface{}{float64(1), float64(2)}},
+		{"foo: bar\n", map[string]interface{}{"foo": "bar"}},
+		{"# Settings.\n\nfoo:\n  - bar\n", map[string]interface{}{"foo": []interface{}{"bar"}}},
+		{"---\nfoo: 1\n", map[string]interface{}{"foo": float64(1)}},
+		{"- a\n- b\n", []interface{}{"a", "b"}},
+		{"\"a: b\"", "a: b"},
+	} {
+		m := NewMain()
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			return []byte(tt.content), nil
+		}
+
+		if err := m.ParseFlags([]string{"-data", `@data`}); err != nil {
+			t.Fatalf("%q: %s", tt.content, err)
+		} else if !reflect.DeepEqual(m.Data, tt.data) {
+			t.Fatalf("%q: unexpected data: %#v", tt.content, m.Data)
+		}
+	}
+}
+
+// Ensure a known extension takes precedence over the contents of a data file.
+func TestMain_ParseFlags_Data_File_SniffFormat_ExtWins(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("foo: bar\n"), nil
+	}
+
+	if err := m.ParseFlags([]string{"-data", `@data.json`}); err == nil || !strings.Contains(err.Error(), "invalid character") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure data can be read from stdin as JSON by default.
+func TestMain_ParseFlags_Data_Stdin(t *testing.T) {
+	m := NewMain()
+	m.Stdin.WriteString(`{"foo":"bar"}`)
+	if err := m.ParseFlags([]string{"-data", "-"}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"foo": "bar"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure data can be read from stdin in other formats.
+func TestMain_ParseFlags_Data_StdinFormat(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		input  string
+	}{
+		{"yaml", "foo: bar\nn: 1\n"},
+		{"toml", "foo = \"bar\"\nn = 1\n"},
+	} {
+		m := NewMain()
+		m.Stdin.WriteString(tt.input)
+		if err := m.ParseFlags([]string{"-stdin-format", tt.format, "-data", "-"}); err != nil {
+			t.Fatalf("%s: %s", tt.format, err)
+		} else if foo := m.Data.(map[string]interface{})["foo"]; foo != "bar" {
+			t.Fatalf("%s: unexpected data: %#v", tt.format, m.Data)
+		}
+	}
+}
+
+// Ensure an invalid stdin format returns an error.
+func TestMain_ParseFlags_Data_StdinFormat_Invalid(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-stdin-format", "xml"}); err == nil || err.Error() != `invalid -stdin-format value: xml` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure comments can be used in JSON data, without affecting strings.
+func TestMain_ParseFlags_Data_JSONC(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{
+	// The service URL.
+	"url": "http://example.com//a/*b*/",/* trailing */
+	"quote": "\"//", // "ignored"
+	/* block
+	   comment */ "n": 1
+}`), nil
+	}
+
+	if err := m.ParseFlags([]string{"-data", `@data.jsonc`}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"url": "http://example.com//a/*b*/", "quote": `"//`, "n": float64(1)}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure comments are only allowed in JSON data when enabled.
+func TestMain_ParseFlags_Data_JSONC_Flag(t *testing.T) {
+	if err := NewMain().ParseFlags([]string{"-data", `{"a":1} // comment`}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-jsonc", "-data", `{"a":1} // comment`}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"a": float64(1)}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure data can be parsed from the output of a command.
+func TestMain_ParseFlags_DataCmd(t *testing.T) {
+	m := NewMain()
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		if command != "gen --json" {
+			t.Fatalf("unexpected command: %s", command)
+		}
+		_, err := io.WriteString(stdout, `{"foo":"bar"}`)
+		return err
+	}
+
+	if err := m.ParseFlags([]string{"-data-cmd", "gen --json"}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"foo": "bar"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
+// Ensure a failed data command returns an error including its stderr.
+func TestMain_ParseFlags_DataCmd_Error(t *testing.T) {
+	m := NewMain()
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		io.WriteString(stderr, "gen: no such model\n")
+		return errors.New("exit status 1")
+	}
+
+	if err := m.ParseFlags([]string{"-data-cmd", "gen"}); err == nil || err.Error() != `data command: exit status 1: gen: no such model` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure data and a data command cannot both be specified.
+func TestMain_ParseFlags_DataCmd_ErrDataConflict(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-data", "{}", "-data-cmd", "gen"}); err == nil || err.Error() != `cannot specify both -data and -data-cmd` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure build metadata is available to templates separately from data.
+func TestMain_ParseFlags_Meta(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{
+		"-meta", "version=1.2.0", "-meta", "commit=abc123", "-meta", "flags=a=b,c",
+		"-data", `{"version":"data"}`,
+		"-template-string", `{{meta.version}} {{meta.commit}} {{meta.flags}} [{{index meta "missing"}}] {{.version}}`,
+	}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Meta, map[string]string{"version": "1.2.0", "commit": "abc123", "flags": "a=b,c"}) {
+		t.Fatalf("unexpected meta: %#v", m.Meta)
+	} else if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `1.2.0 abc123 a=b,c [] data` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure build metadata must be a key=value pair.
+func TestMain_ParseFlags_ErrInvalidMeta(t *testing.T) {
+	for _, v := range []string{"version", "=1.2.0"} {
+		if err := NewMain().ParseFlags([]string{"-meta", v}); err == nil || err.Error() != `invalid -meta value: `+v {
+			t.Fatalf("%s: unexpected error: %v", v, err)
+		}
+	}
+}
+
+// Ensure arguments after a "--" separator are passed to templates.
+func TestMain_ParseFlags_Args(t *testing.T) {
+	for _, tt := range []struct {
+		args  []string
+		paths []string
+		extra []string
+	}{
+		{[]string{"a.tmpl", "b.tmpl"}, []string{"a.tmpl", "b.tmpl"}, nil},
+		{[]string{"a.tmpl", "--", "x", "-y"}, []string{"a.tmpl"}, []string{"x", "-y"}},
+		{[]string{"a.tmpl", "--"}, []string{"a.tmpl"}, []string{}},
+		{[]string{"-no-header", "--", "x", "--", "y"}, nil, []string{"x", "--", "y"}},
+		{[]string{"-record-separator", "--", "sep.txt.tmpl"}, []string{"sep.txt.tmpl"}, nil},
+		{[]string{"-record-separator", "--", "--", "x"}, nil, []string{"x"}},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(tt.args); err != nil {
+			t.Fatal(err)
+		} else if len(m.Paths) != len(tt.paths) || (len(tt.paths) > 0 && !reflect.DeepEqual(m.Paths, tt.paths)) {
+			t.Fatalf("%v: unexpected paths: %#v", tt.args, m.Paths)
+		} else if len(m.Args) != len(tt.extra) || (len(tt.extra) > 0 && !reflect.DeepEqual(m.Args, tt.extra)) {
+			t.Fatalf("%v: unexpected args: %#v", tt.args, m.Args)
+		}
+	}
+}
+
+// Ensure extra arguments are available to templates.
+func TestMain_Run_Args(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template-string", `{{len args}}:{{range args}} {{.}}{{end}}{{if in "debug" args}} (debug){{end}}`, "--", "prod", "debug"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `2: prod debug (debug)` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+}
+
+// Ensure relative template, data & read directory paths can be resolved against the executable.
+func TestMain_ParseFlags_RelativeToExe(t *testing.T) {
+	m := NewMain()
+	m.OS.ExecutableFn = func() (string, error) { return "/opt/tmpl/bin/tmpl", nil }
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename != "/opt/tmpl/bin/data.json" {
+			t.Fatalf("unexpected filename: %s", filename)
+		}
+		return []byte(`{"foo":"bar"}`), nil
+	}
+
+	if err := m.ParseFlags([]string{"-relative-to-exe", "-data", "@data.json", "-allow-read", "secrets", "-allow-read", "/run/secrets", "a.tmpl", "/abs/b.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m.Paths, []string{"/opt/tmpl/bin/a.tmpl", "/abs/b.tmpl"}) {
+		t.Fatalf("unexpected paths: %+v", m.Paths)
+	} else if !reflect.DeepEqual(m.AllowedReadDirs, []string{"/opt/tmpl/bin/secrets", "/run/secrets"}) {
+		t.Fatalf("unexpected read dirs: %+v", m.AllowedReadDirs)
+	} else if !reflect.DeepEqual(m.Data, map[string]interface{}{"foo": "bar"}) {
+		t.Fatalf("unexpected data: %#v", m.Data)
+	}
+}
+
 // Ensure a basic template file can be processed.
 func TestMain_Run(t *testing.T) {
 	m := NewMain()
 	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
-		if filename != "a.tmpl" {
-			t.Fatalf("unexpected filename: %s", filename)
+		if filename != "a.tmpl" {
+			t.Fatalf("unexpected filename: %s", filename)
+		}
+		return &fileInfo{mode: 0666}, nil
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename != "a.tmpl" {
+			t.Fatalf("unexpected filename: %s", filename)
+		}
+		return []byte(`hi {{.name}}, you are {{.age}}`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename != "a" {
+			t.Fatalf("unexpected filename: %s", filename)
+		} else if string(data) != `hi bob, you are 12` {
+			t.Fatalf("unexpected data: %s", data)
+		} else if perm != 0666 {
+			t.Fatalf("unexpected perm: %s", perm)
+		}
+		return nil
+	}
+	m.Paths = []string{"a.tmpl"}
+	m.Data = map[string]interface{}{"name": "bob", "age": 12}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a file can be processed against array data.
+func TestMain_Run_Array(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`I like{{range .}} ({{.}}){{end}}`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if string(data) != `I like (apple) (pear)` {
+			t.Fatalf("unexpected data: %s", data)
+		}
+		return nil
+	}
+
+	m.Paths = []string{"a.tmpl"}
+	m.Data = []interface{}{"apple", "pear"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a file will add a comment header if generating a Go file.
+func TestMain_Run_Header_Go(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("\n\n\n\n\n\npackage foo"), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if string(data) != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\npackage foo\n" {
+			t.Fatalf("unexpected data: %s", data)
+		}
+		return nil
+	}
+
+	m.Paths = []string{"x.go.tmpl"}
+	m.Data = []interface{}{"apple", "pear"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure non-Go files use the comment prefix for their extension when enabled.
+func TestMain_Run_Header_All(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-header-all", "-header-comment", ".txt=;", "a.sql.tmpl", "b.sh.tmpl", "c.txt.tmpl", "d.json.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "b.sh.tmpl" {
+			return []byte("#!/bin/sh\necho hi\n"), nil
+		}
+		return []byte("X\n"), nil
+	}
+
+	outputs := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(outputs, map[string]string{
+		"a.sql":  "-- Generated by tmpl\n-- https://github.com/benbjohnson/tmpl\n--\n-- DO NOT EDIT!\n-- Source: a.sql.tmpl\n\nX\n",
+		"b.sh":   "#!/bin/sh\n# Generated by tmpl\n# https://github.com/benbjohnson/tmpl\n#\n# DO NOT EDIT!\n# Source: b.sh.tmpl\n\necho hi\n",
+		"c.txt":  "; Generated by tmpl\n; https://github.com/benbjohnson/tmpl\n;\n; DO NOT EDIT!\n; Source: c.txt.tmpl\n\nX\n",
+		"d.json": "X\n",
+	}) {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	}
+}
+
+// Ensure non-Go files have no header by default.
+func TestMain_Run_Header_NonGo(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("X"), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if string(data) != "X" {
+			t.Fatalf("unexpected data: %s", data)
+		}
+		return nil
+	}
+
+	m.Paths = []string{"a.sql.tmpl"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure outputs can be written to a temporary directory for preview.
+func TestMain_Run_OutputDirTemp(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-output-dir-temp", "a.tmpl", "sub/b.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.TempDirFn = func(dir, pattern string) (string, error) {
+		return "/tmp/tmpl-1", nil
+	}
+
+	var dirs []string
+	m.OS.MkdirAllFn = func(path string, perm os.FileMode) error {
+		dirs = append(dirs, path)
+		return nil
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("package b"), nil
+	}
+
+	outputs := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if len(outputs) != 2 || outputs["/tmp/tmpl-1/a"] == "" || outputs["/tmp/tmpl-1/sub/b.go"] == "" {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	} else if !reflect.DeepEqual(dirs, []string{"/tmp/tmpl-1", "/tmp/tmpl-1/sub"}) {
+		t.Fatalf("unexpected dirs: %#v", dirs)
+	} else if s := m.Stdout.String(); s != "/tmp/tmpl-1\na.tmpl\t/tmp/tmpl-1/a\nsub/b.go.tmpl\t/tmp/tmpl-1/sub/b.go\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+// Ensure outputs outside the working directory cannot escape the temporary directory.
+func TestMain_Run_OutputDirTemp_ErrEscape(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-output-dir-temp", "../p1.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.TempDirFn = func(dir, pattern string) (string, error) {
+		return "/tmp/tmpl-1", nil
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("x"), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+	if err := m.Run(); err == nil || err.Error() != `-output-dir-temp: output path is outside the working directory: ../p1.txt` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure outputs cannot be skipped as fresh when previewing.
+func TestMain_Run_OutputDirTemp_ErrIncremental(t *testing.T) {
+	for _, args := range [][]string{
+		{"-output-dir-temp", "-incremental", "a.tmpl"},
+		{"-output-dir-temp", "-since", "main", "a.tmpl"},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		} else if err := m.Run(); err == nil || err.Error() != `cannot use -output-dir-temp with -incremental or -since` {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// Ensure only outputs older than their template or data file are regenerated.
+func TestMain_Run_Incremental(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`"x"`), nil
+	}
+	if err := m.ParseFlags([]string{"-incremental", "-data", "@data.json", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output "a" is fresh, "b" is older than its template, and "c" is missing.
+	mtimes := map[string]int{"data.json": 1, "a.tmpl": 2, "a": 3, "b.tmpl": 3, "b": 2, "c.tmpl": 1}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		mtime, ok := mtimes[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &fileInfo{mode: 0666, modTime: time.Unix(int64(mtime), 0)}, nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"b", "c"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+
+	// Updating the data file makes all outputs stale.
+	written, mtimes["c"], mtimes["data.json"] = nil, 3, 4
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure outputs are regenerated when the prelude changes.
+func TestMain_Run_Incremental_Prelude(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`"x"`), nil
+	}
+	if err := m.ParseFlags([]string{"-incremental", "-data", "@data.json", "-prelude", "prelude.tmpl", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mtimes := map[string]int{"data.json": 1, "prelude.tmpl": 1, "a.tmpl": 1, "a": 2, "b.tmpl": 1, "b": 2}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		mtime, ok := mtimes[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &fileInfo{mode: 0666, modTime: time.Unix(int64(mtime), 0)}, nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if len(written) != 0 {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+
+	// Updating the prelude makes all outputs stale.
+	mtimes["prelude.tmpl"] = 3
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a", "b"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure the inputs recorded in the previous depfile are checked when building
+// incrementally.
+func TestMain_Run_Incremental_Depfile(t *testing.T) {
+	m := NewMain()
+	depfile := "a: data.json secret.txt\nb: data.json removed.txt\nc: data.json\n"
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "deps.d" {
+			return []byte(depfile), nil
+		}
+		return []byte(`"x"`), nil
+	}
+	if err := m.ParseFlags([]string{"-incremental", "-data", "@data.json", "-depfile", "deps.d", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output "a" is older than the file it read with fileContents and a file
+	// that "b" read no longer exists.
+	mtimes := map[string]int{"data.json": 1, "secret.txt": 3, "a.tmpl": 1, "a": 2, "b.tmpl": 1, "b": 2, "c.tmpl": 1, "c": 2}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		mtime, ok := mtimes[filename]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &fileInfo{mode: 0666, modTime: time.Unix(int64(mtime), 0)}, nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename == "deps.d" {
+			depfile = string(data)
+		}
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a", "b", "deps.d"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	} else if depfile != "a: data.json a.tmpl\nb: data.json b.tmpl\nc: data.json\n" {
+		t.Fatalf("unexpected depfile: %q", depfile)
+	}
+}
+
+// Ensure only templates changed since a git ref are regenerated.
+func TestMain_Run_Since(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`"x"`), nil
+	}
+	if err := m.ParseFlags([]string{"-since", "origin/it's", "-data", "@data.json", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Template "b" changed and output "c" is missing.
+	changed := "b.tmpl\nother.txt\n"
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		if command != `git diff --name-only --relative 'origin/it'\''s' -- && git ls-files --others --exclude-standard` {
+			t.Fatalf("unexpected command: %s", command)
+		}
+		_, err := io.WriteString(stdout, changed)
+		return err
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		if filename == "c" {
+			return nil, os.ErrNotExist
+		}
+		return &fileInfo{mode: 0666}, nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"b", "c"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+
+	// Changing the data file regenerates all outputs.
+	written, changed = nil, "data.json\n"
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure templates are regenerated when an input recorded in the previous
+// depfile changed since the git ref.
+func TestMain_Run_Since_Depfile(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "deps.d" {
+			return []byte("a: a.tmpl secret.txt\nb: b.tmpl\n"), nil
+		}
+		return []byte("x"), nil
+	}
+	if err := m.ParseFlags([]string{"-since", "main", "-depfile", "deps.d", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		_, err := io.WriteString(stdout, "secret.txt\n")
+		return err
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		return &fileInfo{mode: 0666}, nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a", "deps.d"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure all templates are regenerated, with a warning, if git fails.
+func TestMain_Run_Since_GitUnavailable(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-since", "main", "a.go.tmpl", "b.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("package foo"), nil
+	}
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		io.WriteString(stderr, "sh: git: not found")
+		return errors.New("exit status 127")
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a.go", "b.go"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	} else if s := m.Stderr.String(); s != "warning: -since: cannot list changed files, processing all templates: exit status 127: sh: git: not found\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure a plugin that cannot be loaded returns an error.
+func TestMain_ParseFlags_Plugin_ErrOpen(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-plugin", "missing.so", "a.tmpl"}); err == nil || !strings.HasPrefix(err.Error(), `plugin missing.so: `) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure existing output files can be backed up before being overwritten.
+func TestMain_Run_Backup(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-backup", "-backup-suffix", ".orig", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a":
+			return []byte("old"), nil
+		case "b":
+			return nil, os.ErrNotExist
+		default:
+			return []byte("new"), nil
+		}
+	}
+
+	outputs := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(outputs, map[string]string{"a.orig": "old", "a": "new", "b": "new"}) {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	}
+}
+
+// Ensure template source can be rendered from a reader.
+func TestMain_RenderReader(t *testing.T) {
+	m := NewMain()
+	if output, err := m.RenderReader(strings.NewReader(`{{.}}`), "a.txt.tmpl", "X"); err != nil {
+		t.Fatal(err)
+	} else if string(output) != `X` {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure template source rendered from a reader uses the name's header & formatting.
+func TestMain_RenderReader_Go(t *testing.T) {
+	m := NewMain()
+	if output, err := m.RenderReader(strings.NewReader("package {{.}};var  x=1"), "a.go.tmpl", "foo"); err != nil {
+		t.Fatal(err)
+	} else if string(output) != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: a.go.tmpl\n\npackage foo\n\nvar x = 1\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure a renderer can execute its template repeatedly against different data.
+func TestMain_NewRenderer(t *testing.T) {
+	m := NewMain()
+	r, err := m.NewRenderer("a.txt.tmpl", []byte(`{{. | upper}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"foo", "bar"} {
+		if output, err := r.Execute(data); err != nil {
+			t.Fatal(err)
+		} else if string(output) != strings.ToUpper(data) {
+			t.Fatalf("unexpected output: %q", output)
+		}
+	}
+}
+
+// Ensure a renderer returns parse errors on creation.
+func TestMain_NewRenderer_ErrParse(t *testing.T) {
+	m := NewMain()
+	if _, err := m.NewRenderer("a.txt.tmpl", []byte(`{{`)); err == nil || !strings.Contains(err.Error(), `a.txt.tmpl:1: unclosed action`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+const benchmarkTemplate = `{{range .}}{{.Name | title}}: {{.Value | printf "%05d"}}
+{{end}}`
+
+var benchmarkData = []struct {
+	Name  string
+	Value int
+}{{"foo", 1}, {"bar", 2}, {"baz", 3}}
+
+// Benchmark rendering a template that is parsed once.
+func BenchmarkRenderer_Execute(b *testing.B) {
+	m := NewMain()
+	r, err := m.NewRenderer("a.txt.tmpl", []byte(benchmarkTemplate))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.Execute(benchmarkData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark rendering a template that is reparsed on every call.
+func BenchmarkMain_RenderReader(b *testing.B) {
+	m := NewMain()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.RenderReader(strings.NewReader(benchmarkTemplate), "a.txt.tmpl", benchmarkData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Ensure a missing file returns an error by default.
+func TestMain_Run_MissingFile_Error(t *testing.T) {
+	m := NewMain()
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+
+	m.Paths = []string{"a.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `file not found` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a missing file can be skipped with a warning.
+func TestMain_Run_MissingFile_Skip(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-on-missing-file", "skip", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		if filename == "a.tmpl" {
+			return nil, os.ErrNotExist
+		}
+		return DefaultOSStat(filename)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`b`), nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"b"}) {
+		t.Fatalf("unexpected writes: %+v", written)
+	} else if s := m.Stderr.String(); s != "warning: skipping missing file: a.tmpl\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure an invalid missing file behavior returns an error.
+func TestMain_ParseFlags_OnMissingFile_Invalid(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-on-missing-file", "ignore"}); err == nil || err.Error() != `invalid -on-missing-file value: ignore` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a manifest of generated files can be written after processing.
+func TestMain_Run_Manifest(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-manifest", "gen.json", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(filename), nil
+	}
+
+	var manifest []byte
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename == "gen.json" {
+			manifest = data
+		}
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if string(manifest) != `{
+	"files": [
+		{
+			"path": "a",
+			"sha256": "6810cfffc16cbed0e7ff40fdd762dc7ba29a659bc58665ab26c29ce081320b7e"
+		},
+		{
+			"path": "b",
+			"sha256": "417b46fa83ade18014662a83799a65cdfd1f11af2a6177e5696b297adf343a5a"
+		}
+	]
+}
+` {
+		t.Fatalf("unexpected manifest: %s", manifest)
+	}
+}
+
+// Ensure files in the previous manifest that are no longer generated are
+// removed, unless they were modified.
+func TestMain_Run_Prune(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-manifest", "gen.json", "-prune", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// "b" is stale, "c" is stale but was edited, and "d" was already removed.
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.tmpl":
+			return []byte("a.tmpl"), nil
+		case "b":
+			return []byte("b.tmpl"), nil
+		case "c":
+			return []byte("edited"), nil
+		case "gen.json":
+			return []byte(`{"files": [
+				{"path": "a", "sha256": "6810cfffc16cbed0e7ff40fdd762dc7ba29a659bc58665ab26c29ce081320b7e"},
+				{"path": "b", "sha256": "417b46fa83ade18014662a83799a65cdfd1f11af2a6177e5696b297adf343a5a"},
+				{"path": "c", "sha256": "6810cfffc16cbed0e7ff40fdd762dc7ba29a659bc58665ab26c29ce081320b7e"},
+				{"path": "d", "sha256": "417b46fa83ade18014662a83799a65cdfd1f11af2a6177e5696b297adf343a5a"}
+			]}`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	var removed []string
+	m.OS.RemoveFn = func(name string) error {
+		removed = append(removed, name)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(removed, []string{"b"}) {
+		t.Fatalf("unexpected removals: %#v", removed)
+	} else if s := m.Stderr.String(); s != "warning: prune: not removing c as it was modified after it was generated\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure pruning requires a manifest.
+func TestMain_Run_Prune_ErrNoManifest(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-prune", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err == nil || err.Error() != `-prune requires -manifest` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure temporary outputs cannot replace the manifest or prune real outputs.
+func TestMain_Run_Prune_ErrOutputDirTemp(t *testing.T) {
+	m := NewMain()
+	m.OS.RemoveFn = func(name string) error {
+		t.Fatalf("unexpected remove: %s", name)
+		return nil
+	}
+	m.OS.TempDirFn = func(dir, pattern string) (string, error) {
+		t.Fatal("unexpected temp dir")
+		return "", nil
+	}
+	if err := m.ParseFlags([]string{"-manifest", "m.json", "-prune", "-output-dir-temp", "a.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err == nil || err.Error() != `cannot use -output-dir-temp with -manifest or -prune` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the post-hook is run once with the written paths on stdin.
+func TestMain_Run_PostHook(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-post-hook", "xargs goimports -w", "a.go.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.go.tmpl" {
+			return []byte(`package a`), nil
+		}
+		return []byte(`b`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	var n int
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		n++
+		if command != "xargs goimports -w" {
+			t.Fatalf("unexpected command: %s", command)
+		} else if b, _ := ioutil.ReadAll(stdin); string(b) != "a.go\nb\n" {
+			t.Fatalf("unexpected stdin: %q", b)
+		}
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("unexpected run count: %d", n)
+	}
+}
+
+// Ensure a failed post-hook fails the run.
+func TestMain_Run_PostHook_Error(t *testing.T) {
+	m := NewMain()
+	m.Paths, m.PostHook = []string{"a.tmpl"}, "check"
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return []byte(`a`), nil }
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		return errors.New("exit status 1")
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `post-hook "check": exit status 1` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the post-hook is not run if no files are written.
+func TestMain_Run_PostHook_NoFiles(t *testing.T) {
+	m := NewMain()
+	m.Paths, m.PostHook = []string{"a.tmpl"}, "check"
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return []byte(`{{skipFile}}`), nil }
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		t.Fatal("unexpected post-hook")
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a depfile lists the files each output was generated from.
+func TestMain_Run_Depfile(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "data.json":
+			return []byte(`{"name":"x"}`), nil
+		case "helpers.tmpl":
+			return []byte(`{{define "h"}}h{{end}}`), nil
+		case "a.tmpl":
+			return []byte(`{{fileContents "license.txt"}} {{(fromJSONFile "extra.json").n}}`), nil
+		case "license.txt":
+			return []byte(`MIT`), nil
+		case "extra.json":
+			return []byte(`{"n":1}`), nil
+		default:
+			return []byte(`{{template "h"}}`), nil
+		}
+	}
+
+	var depfile string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename == "gen.d" {
+			depfile = string(data)
+		}
+		return nil
+	}
+
+	if err := m.ParseFlags([]string{"-depfile", "gen.d", "-data", "@data.json", "-prelude", "helpers.tmpl", "a.tmpl", "my dir/b.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if depfile != "a: data.json helpers.tmpl a.tmpl license.txt extra.json\n"+
+		"my\\ dir/b: data.json helpers.tmpl my\\ dir/b.tmpl\n" {
+		t.Fatalf("unexpected depfile: %q", depfile)
+	}
+}
+
+// Ensure each output of a data directory depends only on its own data file.
+func TestMain_Run_Depfile_DataDir(t *testing.T) {
+	m := NewMain()
+	m.TemplatePath, m.DataDir, m.OutDir, m.DepfilePath = "model.txt.tmpl", "models", "gen", "gen.d"
+	m.OS.ReadDirFn = func(dirname string) ([]os.FileInfo, error) {
+		return []os.FileInfo{&fileInfo{name: "post.json"}, &fileInfo{name: "user.json"}}, nil
+	}
+	m.OS.MkdirAllFn = func(path string, perm os.FileMode) error { return nil }
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return []byte(`{}`), nil }
+
+	var depfile string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename == "gen.d" {
+			depfile = string(data)
+		}
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if depfile != "gen/post.txt: model.txt.tmpl models/post.json\ngen/user.txt: model.txt.tmpl models/user.json\n" {
+		t.Fatalf("unexpected depfile: %q", depfile)
+	}
+}
+
+// Ensure output modes can be set by extension.
+func TestMain_Run_PermMap(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-perm-map", ".sh=0755,.go=0644", "-perm-map", ".sh=0700", "run.sh.tmpl", "a.go.tmpl", "b.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.go.tmpl" {
+			return []byte(`package a`), nil
+		}
+		return []byte(`x`), nil
+	}
+
+	perms := make(map[string]os.FileMode)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		perms[filename] = perm
+		return nil
+	}
+	chmods := make(map[string]os.FileMode)
+	m.OS.ChmodFn = func(name string, mode os.FileMode) error {
+		chmods[name] = mode
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(perms, map[string]os.FileMode{"run.sh": 0755, "a.go": 0644, "b.txt": 0666}) {
+		t.Fatalf("unexpected perms: %v", perms)
+	} else if !reflect.DeepEqual(chmods, map[string]os.FileMode{"run.sh": 0755, "a.go": 0644}) {
+		t.Fatalf("unexpected chmods: %v", chmods)
+	}
+}
+
+// Ensure invalid output mode rules are rejected.
+func TestMain_ParseFlags_ErrInvalidPermMap(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		err   string
+	}{
+		{"sh=0755", `invalid -perm-map value: sh=0755`},
+		{".sh", `invalid -perm-map value: .sh`},
+		{".sh=0855", `invalid -perm-map mode: .sh=0855`},
+		{".sh=01755", `invalid -perm-map mode: .sh=01755`},
+		{".sh=rwx", `invalid -perm-map mode: .sh=rwx`},
+	} {
+		if err := NewMain().ParseFlags([]string{"-perm-map", tt.value}); err == nil || err.Error() != tt.err {
+			t.Fatalf("%s: unexpected error: %v", tt.value, err)
+		}
+	}
+}
+
+// Ensure overwrite protection allows replacing previously generated files.
+func TestMain_Run_OverwriteProtect_Generated(t *testing.T) {
+	m := NewMain()
+	m.OverwriteProtect = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl":
+			return []byte("package foo"), nil
+		case "x.go":
+			return []byte("// Code generated by tmpl; DO NOT EDIT.\n\npackage foo\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	var written bool
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = true
+		return nil
+	}
+
+	m.Paths = []string{"x.go.tmpl"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !written {
+		t.Fatal("expected write")
+	}
+}
+
+// Ensure overwrite protection ignores markers outside the header line.
+func TestMain_Run_OverwriteProtect_MarkerInBody(t *testing.T) {
+	m := NewMain()
+	m.OverwriteProtect = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl":
+			return []byte("package foo"), nil
+		case "x.go":
+			return []byte("package foo\n\n// Code generated by tmpl; DO NOT EDIT.\nconst Header = \"Generated by tmpl\"\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatal("unexpected write")
+		return nil
+	}
+
+	m.Paths = []string{"x.go.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `refusing to overwrite file not generated by tmpl: x.go` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure outputs written in another encoding are recognized as generated.
+func TestMain_Run_OverwriteProtect_Encoding(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-overwrite-protect", "-encoding", "utf-16le", "-generated-notice", ".json=key", "x.go.tmpl", "y.json.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(map[string][]byte)
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl":
+			return []byte("package foo"), nil
+		case "y.json.tmpl":
+			return []byte(`{"a": 1}`), nil
+		}
+		if buf, ok := written[filename]; ok {
+			return buf, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = data
+		return nil
+	}
+
+	// Regenerating overwrites the encoded outputs of the first run.
+	for i := 0; i < 2; i++ {
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Ensure files with the header written by tmpl can be overwritten.
+func TestMain_Run_OverwriteProtect_Header(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-overwrite-protect", "x.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl":
+			return []byte("package foo"), nil
+		case "x.go":
+			return []byte("// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\npackage foo\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	var output string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		output = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if output != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\npackage foo\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure a custom marker is written to headers and used for overwrite protection.
+func TestMain_Run_OverwriteProtect_HeaderMarker(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-overwrite-protect", "-header-marker", "@generated", "x.go.tmpl", "y.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl", "y.go.tmpl":
+			return []byte("package foo"), nil
+		case "x.go":
+			return []byte("// @generated by tmpl\n\npackage foo\n"), nil
+		case "y.go":
+			return []byte("// Code generated by tmpl; DO NOT EDIT.\n\npackage foo\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `refusing to overwrite file not generated by tmpl: y.go` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"x.go": "// @generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\npackage foo\n",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure a key notice is inserted as the first key of JSON object outputs.
+func TestMain_Run_GeneratedNotice_Key(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-generated-notice", ".json=key", "a.json.tmpl", "b.json.tmpl", "c.json.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.json.tmpl":
+			return []byte("{\n  \"name\": \"foo\"\n}\n"), nil
+		case "b.json.tmpl":
+			return []byte("{}"), nil
+		case "c.json.tmpl":
+			return []byte("[1, 2]"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `c.json: -generated-notice key requires a JSON object` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"a.json": "{\n  \"_generated\": true,\n  \"name\": \"foo\"\n}\n",
+		"b.json": `{"_generated": true}`,
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure a file notice is written alongside the output and is recognized by
+// overwrite protection.
+func TestMain_Run_GeneratedNotice_File(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-overwrite-protect", "-generated-notice", ".csv=file", "a.csv.tmpl", "b.csv.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.csv.tmpl", "b.csv.tmpl", "a.csv", "b.csv":
+			return []byte("x,y\n"), nil
+		case "a.csv.generated":
+			return []byte("Generated by tmpl\nDO NOT EDIT!\nSource: a.csv.tmpl\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `refusing to overwrite file not generated by tmpl: b.csv` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"a.csv":           "x,y\n",
+		"a.csv.generated": "Generated by tmpl\nDO NOT EDIT!\nSource: a.csv.tmpl\n",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure an unknown notice style returns an error.
+func TestMain_ParseFlags_GeneratedNotice_ErrStyle(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-generated-notice", ".json=comment", "a.json.tmpl"}); err == nil || err.Error() != `invalid -generated-notice style: .json=comment` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure overwrite protection allows writing files that do not exist yet.
+func TestMain_Run_OverwriteProtect_NotExist(t *testing.T) {
+	m := NewMain()
+	m.OverwriteProtect = true
+	if output, err := m.RunTemplate(`foo`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `foo` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure overwrite protection refuses to replace hand-written files.
+func TestMain_Run_OverwriteProtect_HandWritten(t *testing.T) {
+	m := NewMain()
+	m.OverwriteProtect = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "x.go.tmpl":
+			return []byte("package foo"), nil
+		case "x.go":
+			return []byte("package foo\n\nfunc main() {}\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatal("unexpected write")
+		return nil
+	}
+
+	m.Paths = []string{"x.go.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `refusing to overwrite file not generated by tmpl: x.go` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure warnings are colorized when color output is forced on.
+func TestMain_Run_Color_Always(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-color", "always", "-on-missing-file", "skip", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stderr.String(); s != "\x1b[33mwarning:\x1b[0m skipping missing file: a.tmpl\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure warnings are not colorized in auto mode when Stderr is not a terminal.
+func TestMain_Run_Color_Auto(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-on-missing-file", "skip", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stderr.String(); s != "warning: skipping missing file: a.tmpl\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure errors & warnings can be written as JSON diagnostics.
+func TestMain_ErrorsAsJSON(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-errors-as-json", "-on-missing-file", "skip", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		if filename == "a.tmpl" {
+			return nil, os.ErrNotExist
+		}
+		return DefaultOSStat(filename)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("\n{{fail \"bad\"}}"), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	err := m.Run()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	m.PrintError(err)
+	m.PrintError(errors.New("other"))
+
+	if s := m.Stderr.String(); s != `{"kind":"warning","message":"skipping missing file: a.tmpl"}`+"\n"+
+		`{"kind":"template","file":"b.tmpl","line":2,"column":2,"message":"executing \"b.tmpl\" at \u003cfail \"bad\"\u003e: error calling fail: bad"}`+"\n"+
+		`{"kind":"error","message":"other"}`+"\n" {
+		t.Fatalf("unexpected stderr: %s", s)
+	}
+}
+
+// Ensure an invalid color mode returns an error.
+func TestMain_ParseFlags_Color_Invalid(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-color", "sometimes"}); err == nil || err.Error() != `invalid -color value: sometimes` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an inline template is rendered to stdout.
+func TestMain_Run_TemplateString(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template-string", "hi {{.name}}", "-data", `{"name":"bob"}`}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != `hi bob` {
+		t.Fatalf("unexpected stdout: %s", s)
+	}
+}
+
+// Ensure an inline template is written to the output path, if specified.
+func TestMain_Run_TemplateString_OutputPath(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template-string", "package {{.}}", "-data", `"foo"`, "-o", "x.go"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if filename != "x.go" {
+			t.Fatalf("unexpected filename: %s", filename)
+		} else if string(data) != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: <template-string>\n\npackage foo\n" {
+			t.Fatalf("unexpected data: %s", data)
+		} else if perm != 0666 {
+			t.Fatalf("unexpected perm: %s", perm)
+		}
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure an inline template cannot be combined with paths.
+func TestMain_Run_TemplateString_ErrPaths(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template-string", "x", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.Run(); err == nil || err.Error() != `cannot specify both -template-string and paths` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an output suffix is inserted before the remaining extension.
+func TestMain_Run_OutSuffix(t *testing.T) {
+	for _, tt := range []struct {
+		path   string
+		output string
+	}{
+		{path: "a.js.tmpl", output: "a.min.js"},
+		{path: "a.b.js.tmpl", output: "a.b.min.js"},
+		{path: "dir.v2/a.tmpl", output: "dir.v2/a.min"},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-out-suffix", ".min", tt.path}); err != nil {
+			t.Fatal(err)
+		}
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+
+		var output string
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			output = filename
+			return nil
+		}
+
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output path: %s", tt.path, output)
+		}
+	}
+}
+
+// Ensure output extensions are replaced by the first matching rule.
+func TestMain_Run_OutExt(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		path   string
+		output string
+	}{
+		{args: []string{"-out-ext", ".js.tmpl=.mjs"}, path: "a.js.tmpl", output: "a.mjs"},
+		{args: []string{"-out-ext", ".js.tmpl=.mjs"}, path: "a.css.tmpl", output: "a.css"},
+		{args: []string{"-out-ext", ".min.js.tmpl=.min.mjs,.js.tmpl=.mjs"}, path: "a.min.js.tmpl", output: "a.min.mjs"},
+		{args: []string{"-out-ext", ".js.tmpl=.mjs", "-out-ext", ".min.js.tmpl=.x"}, path: "a.min.js.tmpl", output: "a.min.mjs"},
+		{args: []string{"-out-ext", ".txt.tmpl=,.tmpl=.out"}, path: "README.txt.tmpl", output: "README"},
+		{args: []string{"-out-ext", ".txt.tmpl=,.tmpl=.out"}, path: "a.tmpl", output: "a.out"},
+		{args: []string{"-out-ext", ".js.tmpl=.mjs", "-out-suffix", ".min"}, path: "a.js.tmpl", output: "a.min.mjs"},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(append(tt.args, tt.path)); err != nil {
+			t.Fatal(err)
+		}
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+
+		var output string
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			output = filename
+			return nil
+		}
+
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		} else if output != tt.output {
+			t.Fatalf("%v %s: unexpected output path: %s", tt.args, tt.path, output)
+		}
+	}
+}
+
+// Ensure invalid output extension rules return an error.
+func TestMain_ParseFlags_OutExt_Invalid(t *testing.T) {
+	for _, v := range []string{".js=.mjs", ".js.tmpl", "js.tmpl=.mjs", ""} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-out-ext", v}); err == nil || err.Error() != `invalid -out-ext value: `+v {
+			t.Fatalf("%q: unexpected error: %v", v, err)
+		}
+	}
+}
+
+// Ensure the template is not overwritten if the output resolves to its path.
+func TestMain_Run_ErrOutputIsInput(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-o", "./a.tmpl", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return []byte(`x`), nil }
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `output path is the same as template path: a.tmpl` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure data is validated against a schema before rendering.
+func TestMain_Run_Schema(t *testing.T) {
+	schema := `{"type":"object","required":["name"],"properties":{"name":{"type":"string"},"age":{"type":"integer"}}}`
+
+	t.Run("OK", func(t *testing.T) {
+		m := NewMain()
+		m.SchemaPath = "schema.json"
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			if filename == "schema.json" {
+				return []byte(schema), nil
+			}
+			return []byte(`hi {{.name}}`), nil
+		}
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+		m.Paths = []string{"a.tmpl"}
+		m.Data = map[string]interface{}{"name": "bob", "age": float64(12)}
+		if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrInvalid", func(t *testing.T) {
+		m := NewMain()
+		m.SchemaPath = "schema.json"
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			if filename == "schema.json" {
+				return []byte(schema), nil
+			}
+			return []byte(`hi {{.name}}`), nil
+		}
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			t.Fatal("unexpected write")
+			return nil
+		}
+
+		m.Paths = []string{"a.tmpl"}
+		m.Data = map[string]interface{}{"nmae": "bob", "age": "12"}
+		if err := m.Run(); err == nil || err.Error() != "data does not match schema schema.json:\n\t(root): name is required\n\tage: Invalid type. Expected: integer, given: string" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("DataKey", func(t *testing.T) {
+		m := NewMain()
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			if filename == "schema.json" {
+				return []byte(schema), nil
+			}
+			return []byte(`hi {{.Values.name}}`), nil
+		}
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+		if err := m.ParseFlags([]string{"-schema", "schema.json", "-data-key", "Values", "-data", `{"name":"bob"}`, "a.tmpl"}); err != nil {
+			t.Fatal(err)
+		} else if err := m.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Ensure output can be wrapped in a prefix & suffix.
+func TestMain_Run_PrefixSuffix(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "end.txt" {
+			return []byte("// endregion\n"), nil
+		}
+		return []byte("var x = {{.}}\n"), nil
+	}
+	if err := m.ParseFlags([]string{"-prefix", "// region generated\n", "-suffix", "@end.txt", "x.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		if string(data) != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\n// region generated\nvar x = 1\n\n// endregion\n" {
+			t.Fatalf("unexpected data: %s", data)
+		}
+		return nil
+	}
+
+	m.Data = 1
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure verify mode reports out of date files without writing.
+func TestMain_Run_Verify(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-verify", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.tmpl", "b.tmpl", "c.tmpl":
+			return []byte(`{{.}}`), nil
+		case "a":
+			return []byte(`new`), nil
+		case "b":
+			return []byte(`old`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	m.Data = "new"
+	if err := m.Run(); err == nil || err.Error() != "generated files are out of date:\n\tb\n\tc" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure diff mode prints a unified diff for each changed file without writing.
+func TestMain_Run_Diff(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-diff", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.tmpl", "b.tmpl", "c.tmpl":
+			return []byte("1\n2\n{{.}}\n4\n5\n6\n7\n8\n9\n10\n11\nend"), nil
+		case "a":
+			return []byte("1\n2\nnew\n4\n5\n6\n7\n8\n9\n10\n11\nend"), nil
+		case "b":
+			return []byte("1\n2\nold\n4\n5\n6\n7\n8\n9\n10\n11\nend\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	m.Data = "new"
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != "--- b\n+++ b\n"+
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-old\n+new\n 4\n 5\n 6\n"+
+		"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-end\n+end\n\\ No newline at end of file\n"+
+		"--- /dev/null\n+++ c\n"+
+		"@@ -0,0 +1,12 @@\n+1\n+2\n+new\n+4\n+5\n+6\n+7\n+8\n+9\n+10\n+11\n+end\n\\ No newline at end of file\n" {
+		t.Fatalf("unexpected stdout: %s", s)
+	}
+}
+
+// Ensure diff mode combined with verify mode fails if files are out of date.
+func TestMain_Run_Diff_Verify(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-diff", "-verify", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.tmpl" {
+			return []byte("new\n"), nil
+		}
+		return []byte("old\n"), nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != "generated files are out of date:\n\ta" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := m.Stdout.String(); s != "--- a\n+++ a\n@@ -1 +1 @@\n-old\n+new\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+// Ensure outputs can be transcoded to another character encoding.
+func TestMain_Run_Encoding(t *testing.T) {
+	for _, tt := range []struct {
+		encoding string
+		output   string
+	}{
+		{"windows-1252", "caf\xe9 \x80"},
+		{"Shift_JIS", "\x93\xfa\x96\x7b"},
+		{"UTF-16BE", "\x00c\x00a\x00f\x00\xe9"},
+		{"utf-8", "café"},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-encoding", tt.encoding, "a.txt.tmpl"}); err != nil {
+			t.Fatal(err)
+		}
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			switch tt.encoding {
+			case "windows-1252":
+				return []byte("café €"), nil
+			case "Shift_JIS":
+				return []byte("日本"), nil
+			}
+			return []byte("café"), nil
+		}
+
+		var output string
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			output = string(data)
+			return nil
+		}
+
+		if err := m.Run(); err != nil {
+			t.Fatalf("%s: %s", tt.encoding, err)
+		} else if output != tt.output {
+			t.Fatalf("%s: unexpected output: %q", tt.encoding, output)
+		}
+	}
+}
+
+// Ensure characters that cannot be encoded return an error with their position.
+func TestMain_Run_Encoding_ErrUnrepresentable(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-encoding", "iso-8859-1", "a.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("café\nπ ≈ 3.14"), nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `a.txt:2:1: cannot encode 'π' as iso-8859-1` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an unsupported encoding returns an error.
+func TestMain_ParseFlags_Encoding_ErrUnsupported(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-encoding", "klingon", "a.txt.tmpl"}); err == nil || err.Error() != `unsupported -encoding value: klingon` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure verify mode succeeds when all files are up to date.
+func TestMain_Run_Verify_UpToDate(t *testing.T) {
+	m := NewMain()
+	m.Verify = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`x`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	m.Paths = []string{"a.tmpl"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure absent keys are an error in strict mode while null values are not.
+func TestMain_Run_StrictMissingData(t *testing.T) {
+	data := map[string]interface{}{"a": nil}
+
+	m := NewMain()
+	m.StrictMissingData = true
+	if output, err := m.RunTemplate(`{{.a}}`, data); err != nil {
+		t.Fatal(err)
+	} else if output != `<no value>` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	m = NewMain()
+	if err := m.ParseFlags([]string{"-strict-missing-data"}); err != nil {
+		t.Fatal(err)
+	} else if _, err := m.RunTemplate(`{{.b}}`, data); err == nil || !strings.Contains(err.Error(), `map has no entry for key "b"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure templates can reference optional fields when no data is provided.
+func TestMain_Run_AllowMissingData(t *testing.T) {
+	const source = `{{with index . "name"}}hello {{.}}{{else}}hello{{end}}`
+	if _, err := NewMain().RunTemplate(source, nil); err == nil || !strings.Contains(err.Error(), `index of untyped nil`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-allow-missing-data"}); err != nil {
+		t.Fatal(err)
+	} else if output, err := m.RunTemplate(source, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `hello` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure outputs can be written to a custom Output instead of files.
+func TestMain_Run_Output(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.go.tmpl":
+			return []byte(`package {{.}}`), nil
+		case "b.txt.tmpl":
+			return []byte(`hello {{.}}`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	fs := make(map[string]string)
+	m.Output = func(path string, data []byte, perm os.FileMode) error {
+		if perm != 0666 {
+			t.Fatalf("unexpected perm: %s", perm)
+		}
+		fs[path] = string(data)
+		return nil
+	}
+
+	m.Paths = []string{"a.go.tmpl", "b.txt.tmpl"}
+	m.Data = "foo"
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(fs, map[string]string{
+		"a.go":  "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: a.go.tmpl\n\npackage foo\n",
+		"b.txt": "hello foo",
+	}) {
+		t.Fatalf("unexpected outputs: %#v", fs)
+	}
+}
+
+// Ensure the manifest, depfile, and backups are also passed to a custom Output.
+func TestMain_Run_Output_Metadata(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-manifest", "manifest.json", "-depfile", "tmpl.d", "-backup", "a.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.txt.tmpl":
+			return []byte(`new`), nil
+		case "a.txt":
+			return []byte(`old`), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	var paths []string
+	m.Output = func(path string, data []byte, perm os.FileMode) error {
+		paths = append(paths, path)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(paths, []string{"a.txt.bak", "a.txt", "manifest.json", "tmpl.d"}) {
+		t.Fatalf("unexpected outputs: %#v", paths)
+	}
+}
+
+// Ensure errors returned by a custom Output are returned.
+func TestMain_Run_Output_Err(t *testing.T) {
+	m := NewMain()
+	m.Output = func(path string, data []byte, perm os.FileMode) error {
+		return errors.New("marker")
+	}
+	if _, err := m.RunTemplate(`x`, nil); err == nil || err.Error() != `marker` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure no output is written for templates that call skipFile.
+func TestMain_Run_SkipFile(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-backup", "a.go.tmpl", "b.txt.tmpl", "c.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.go.tmpl", "b.txt.tmpl":
+			return []byte(`{{if not .enabled}}{{skipFile}}{{end}}package a`), nil
+		case "c.txt.tmpl":
+			return []byte(`c`), nil
+		}
+		return []byte(`old`), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	m.Data = map[string]interface{}{"enabled": false}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"c.txt.bak": "old", "c.txt": "c"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure calling skipFile after streamed output is flushed still skips it.
+func TestMain_Run_SkipFile_Written(t *testing.T) {
+	if output, err := NewMain().RunTemplate(`{{repeat 5000 "x"}}{{skipFile}}`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != "" {
+		t.Fatalf("unexpected output written: %d bytes", len(output))
+	}
+}
+
+// Ensure skipped outputs are not reported as out of date in verify mode.
+func TestMain_Run_SkipFile_Verify(t *testing.T) {
+	m := NewMain()
+	m.Verify = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.tmpl" {
+			return []byte(`{{skipFile}}`), nil
+		}
+		return []byte(`stale`), nil
+	}
+
+	m.Paths = []string{"a.tmpl"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a template can be rendered once per element into a single output.
+func TestMain_Run_Foreach(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-foreach", "-record-separator", "\n", "-prefix", "BEGIN\n", "-suffix", "\nEND", "-data", `[{"name":"a"},{"name":"b"}]`, "x.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`record {{.name}};`), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"x": "BEGIN\nrecord a;\nrecord b;\nEND"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure -foreach returns an error for non-array data or failed elements.
+func TestMain_Run_Foreach_Err(t *testing.T) {
+	m := NewMain()
+	m.Foreach = true
+	if _, err := m.RunTemplate(`{{.}}`, map[string]interface{}{}); err == nil || err.Error() != `-foreach requires array data, got map[string]interface {}` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m = NewMain()
+	m.Foreach = true
+	if _, err := m.RunTemplate(`{{required "name required" .name}}`, []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{}}); err == nil || !strings.HasPrefix(err.Error(), `element 1: template: a.tmpl:1:`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an array in the data can be split into one output per item.
+func TestMain_Run_SplitBy(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-split-by", "models", "-split-name", "{{.name | lower}}.txt", "-data", `{"models":[{"name":"User"},{"name":"Post"}]}`, "gen/model.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`type {{.name}}`), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"gen/user.txt": "type User", "gen/post.txt": "type Post"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure splitting returns an error if two items generate the same path.
+func TestMain_Run_SplitBy_ErrCollision(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-split-by", "models", "-split-name", "{{.name | lower}}.txt", "-data", `{"models":[{"name":"User"},{"name":"USER"}]}`, "model.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	if err := m.Run(); err == nil || err.Error() != `-split-name: multiple items generate output path: user.txt` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure splitting returns an error if an item's path is outside the template directory.
+func TestMain_Run_SplitBy_ErrEscape(t *testing.T) {
+	for _, name := range []string{"../x.txt", "a/../../x.txt", "/tmp/x.txt", "."} {
+		m := NewMain()
+		if err := m.ParseFlags([]string{"-split-by", "models", "-split-name", "{{.name}}", "-data", fmt.Sprintf(`{"models":[{"name":%q}]}`, name), "gen/model.tmpl"}); err != nil {
+			t.Fatal(err)
+		}
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			t.Fatalf("unexpected write: %s", filename)
+			return nil
+		}
+
+		if err := m.Run(); err == nil || err.Error() != `-split-name: output path is outside the template directory: `+name {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
+
+// Ensure splitting returns an error if the key is not an array.
+func TestMain_Run_SplitBy_ErrNotArray(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-split-by", "models", "-split-name", "x", "-data", `{"models":{}}`, "model.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+
+	if err := m.Run(); err == nil || err.Error() != `-split-by: "models" is not an array` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure defines with file names can each be written to their own output.
+func TestMain_Run_DefineFiles(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-define-files", "-data", `"x"`, "gen/all.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{define "file:a.txt"}}A{{template "name" .}}{{end}}` +
+			`{{define "file:b.txt"}}B{{template "name" .}}{{end}}` +
+			`{{define "name"}}{{.}}{{end}}{{define "c.txt"}}{{end}}ignored`), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"gen/a.txt": "Ax", "gen/b.txt": "Bx"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure defines returns an error if two defines generate the same path.
+func TestMain_Run_DefineFiles_ErrCollision(t *testing.T) {
+	m := NewMain()
+	m.DefineFiles = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{define "file:a.txt"}}{{end}}{{define "file:./a.txt"}}{{end}}`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	m.Paths = []string{"all.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `-define-files: "file:./a.txt" and "file:a.txt" generate the same output path: a.txt` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure defines returns an error if a define's path is outside the template directory.
+func TestMain_Run_DefineFiles_ErrEscape(t *testing.T) {
+	for _, name := range []string{"file:../a.txt", "file:x/../../a.txt", "file:/etc/a.txt", "file:", "file:."} {
+		m := NewMain()
+		m.DefineFiles = true
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			return []byte(`{{define "` + name + `"}}{{end}}`), nil
+		}
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			t.Fatalf("unexpected write: %s", filename)
+			return nil
+		}
+
+		m.Paths = []string{"gen/all.tmpl"}
+		if err := m.Run(); err == nil || err.Error() != fmt.Sprintf(`-define-files: output path is outside the template directory: %q`, name) {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
+
+// Ensure defines returns an error if no file templates are defined.
+func TestMain_Run_DefineFiles_ErrNone(t *testing.T) {
+	m := NewMain()
+	m.DefineFiles = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{define "name"}}{{end}}`), nil
+	}
+
+	m.Paths = []string{"all.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `-define-files: no file templates defined: all.tmpl` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an inline template can be rendered to stdout once per JSONL record.
+func TestMain_Run_JSONL_Stdout(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-jsonl", "-record-separator", "---\n", "-template-string", "name: {{.name}}\n"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Stdin.WriteString("{\"name\":\"a\"}\n\n{\"name\":\"b\"}")
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stdout.String(); s != "name: a\n---\nname: b\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+// Ensure template files are rendered to numbered outputs per JSONL record.
+func TestMain_Run_JSONL_Files(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-jsonl", "a.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Stdin.WriteString("1\n2\n")
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("{{.}}"), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"a.1.txt": "1", "a.2.txt": "2"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure an invalid JSONL record returns an error with its line number.
+func TestMain_Run_JSONL_ErrInvalid(t *testing.T) {
+	m := NewMain()
+	m.JSONL, m.TemplateString = true, "{{.}}"
+	m.Stdin.WriteString("1\n\n{\n")
+	if err := m.Run(); err == nil || err.Error() != `stdin:3: unexpected end of JSON input` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a template can be rendered once per file in a data directory.
+func TestMain_Run_DataDir(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template", "model.go.tmpl", "-data-dir", "models", "-outdir", "gen"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.ReadDirFn = func(dirname string) ([]os.FileInfo, error) {
+		if dirname != "models" {
+			t.Fatalf("unexpected dirname: %s", dirname)
+		}
+		return []os.FileInfo{
+			&fileInfo{name: "README.md"},
+			&fileInfo{name: "post.yaml"},
+			&fileInfo{name: "sub", mode: os.ModeDir},
+			&fileInfo{name: "user.json"},
+		}, nil
+	}
+	m.OS.MkdirAllFn = func(path string, perm os.FileMode) error { return nil }
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "model.go.tmpl":
+			return []byte(`package gen; type {{.name}} struct{}`), nil
+		case "models/post.yaml":
+			return []byte(`name: Post`), nil
+		case "models/user.json":
+			return []byte(`{"name":"User"}`), nil
+		default:
+			t.Fatalf("unexpected read: %s", filename)
+			return nil, nil
+		}
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	m.NoHeader = true
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"gen/post.go": "package gen\n\ntype Post struct{}\n",
+		"gen/user.go": "package gen\n\ntype User struct{}\n",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure data files generating the same output path return an error.
+func TestMain_Run_DataDir_ErrCollision(t *testing.T) {
+	m := NewMain()
+	m.TemplatePath, m.DataDir, m.OutDir = "model.txt.tmpl", "models", "gen"
+	m.OS.ReadDirFn = func(dirname string) ([]os.FileInfo, error) {
+		return []os.FileInfo{&fileInfo{name: "user.json"}, &fileInfo{name: "user.yaml"}}, nil
+	}
+	m.OS.MkdirAllFn = func(path string, perm os.FileMode) error { return nil }
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return []byte(`{}`), nil }
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	if err := m.Run(); err == nil || err.Error() != `-data-dir: user.json and user.yaml generate the same output path: gen/user.txt` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a data directory requires a template and an output directory.
+func TestMain_Run_DataDir_ErrRequired(t *testing.T) {
+	m := NewMain()
+	m.DataDir = "models"
+	if err := m.Run(); err == nil || err.Error() != `-template and -outdir required with -data-dir` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure whitespace trim markers are ignored within fenced blocks.
+func TestMain_Run_VerbatimFences(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-verbatim-fence", "```", "-verbatim-fence", "~~~"}); err != nil {
+		t.Fatal(err)
+	}
+	source := "a {{- .}}\n" +
+		"```go\n" +
+		"b {{- . -}} c\n" +
+		"g {{-\t. -}}\t{{-\r\n.\n" +
+		"-}} h\n" +
+		"   ~~~\n" +
+		"```\n" +
+		"d {{- .}}\n" +
+		"  ~~~\n" +
+		"e {{- .}}\n" +
+		"    ~~~\n" +
+		"f {{- .}}\n" +
+		"~~~\n"
+	if output, err := m.RunTemplate(source, "X"); err != nil {
+		t.Fatal(err)
+	} else if output != "aX\n```go\nb X c\ng X\tX h\n   ~~~\n```\ndX\n  ~~~\ne X\n    ~~~\nf X\n~~~\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure defines in a prelude are available to every template.
+func TestMain_Run_Prelude(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-prelude", "helpers.tmpl", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "helpers.tmpl":
+			return []byte(`{{define "greet"}}hello {{. | upper}}{{end}}{{define "x"}}X{{end}}`), nil
+		case "a.tmpl":
+			return []byte(`{{template "greet" "a"}}`), nil
+		default:
+			return []byte(`{{define "x"}}Y{{end}}{{tpl "greet" "b"}} {{template "x"}}`), nil
+		}
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{"a": "hello A", "b": "hello B Y"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure prelude parse errors are reported separately before any template.
+func TestMain_Run_Prelude_ErrParse(t *testing.T) {
+	m := NewMain()
+	m.PreludePath = "helpers.tmpl"
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename != "helpers.tmpl" {
+			t.Fatalf("unexpected read: %s", filename)
+		}
+		return []byte(`{{define "greet"}}`), nil
+	}
+
+	m.Paths = []string{"a.tmpl"}
+	if err := m.Run(); err == nil || !strings.HasPrefix(err.Error(), `prelude: template: helpers.tmpl:1: unexpected EOF`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure Go output that is not a complete file is rejected when checked.
+func TestMain_Run_CheckGoCompiles(t *testing.T) {
+	// Formatting accepts a list of declarations without a package clause.
+	const source = `func F() {}`
+	if output, err := NewMain().RunTemplate(source, nil); err != nil {
+		t.Fatal(err)
+	} else if output == "" {
+		t.Fatal("expected output")
+	}
+
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-check-go-compiles", "a.go.tmpl", "b.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "a.go.tmpl" {
+			return []byte(`package a`), nil
+		}
+		return []byte(source), nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `b.go:7:1: expected 'package', found 'func'` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, []string{"a.go"}) {
+		t.Fatalf("unexpected writes: %v", written)
+	}
+}
+
+// Ensure Go output maps lines back to the template with line directives.
+func TestMain_Run_LineDirectives(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-line-directives", "-no-header", "gen/a.go.tmpl", "gen/b.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "gen/a.go.tmpl":
+			return []byte("package a\n\nconst doc = `\n{{.}}\n`\n{{range $i, $v := .}}\n// F{{$i}} returns.\nfunc F{{$i}}() string {\n\treturn {{$v | quote}}\n}\n{{end}}\n"), nil
+		case "gen/b.txt.tmpl":
+			return []byte("{{range .}}\n{{.}}{{end}}\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	m.Data = []string{"x", "y"}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"gen/a.go":  "//line a.go.tmpl:1\npackage a\n\nconst doc = `\n[x y]\n`\n\n// F0 returns.\nfunc F0() string {\n\treturn \"x\"\n}\n\n// F1 returns.\n//\n//line a.go.tmpl:8\nfunc F1() string {\n\treturn \"y\"\n}\n",
+		"gen/b.txt": "\nx\ny\n",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure NUL bytes in data and captured output are not taken for line markers.
+func TestMain_Run_LineDirectives_NUL(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-line-directives", "-no-header", "a.go.tmpl", "b.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.go.tmpl":
+			return []byte("{{define \"x\"}}ab{{end}}package a\n\nconst s = {{printf \"%q\" .}}\nconst n = {{len (tpl \"x\" .)}}\n"), nil
+		case "b.txt.tmpl":
+			return []byte("a{{.}}b\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	m.Data = "\n\x00"
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"a.go":  "//line a.go.tmpl:1\npackage a\n\nconst s = \"\\n\\x00\"\nconst n = 2\n",
+		"b.txt": "a\n\x00b\n",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure consecutive blank lines can be collapsed into a single line.
+func TestMain_Run_CollapseBlankLines(t *testing.T) {
+	m := NewMain()
+	m.CollapseBlankLines = true
+	if output, err := m.RunTemplate("a:\n{{if false}}\n{{end}}\n  \n\nb: {{.}}\n\n\nc:\n", 1); err != nil {
+		t.Fatal(err)
+	} else if output != "a:\n\nb: 1\n\nc:\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure collapsed blank lines keep CRLF line endings.
+func TestMain_Run_CollapseBlankLines_CRLF(t *testing.T) {
+	m := NewMain()
+	m.CollapseBlankLines = true
+	if output, err := m.RunTemplate("a:\r\n \r\n\r\nb: {{.}}\r\n\r\n", 1); err != nil {
+		t.Fatal(err)
+	} else if output != "a:\r\n\r\nb: 1\r\n\r\n" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+}
+
+// Ensure invalid UTF-8 in a template produces a warning.
+func TestMain_Run_InvalidUTF8_Warning(t *testing.T) {
+	m := NewMain()
+	if output, err := m.RunTemplate("h\xc3llo", nil); err != nil {
+		t.Fatal(err)
+	} else if output != "h\xc3llo" {
+		t.Fatalf("unexpected output: %q", output)
+	} else if s := m.Stderr.String(); s != "warning: a.tmpl: invalid UTF-8 at byte offset 1\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure invalid UTF-8 in a template is an error in strict mode.
+func TestMain_Run_InvalidUTF8_Strict(t *testing.T) {
+	m := NewMain()
+	m.StrictUTF8 = true
+	if _, err := m.RunTemplate("ok: \xff", nil); err == nil || err.Error() != `a.tmpl: invalid UTF-8 at byte offset 4` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure invalid UTF-8 in rendered Go output is an error in strict mode.
+func TestMain_Run_InvalidUTF8_Strict_GoOutput(t *testing.T) {
+	m := NewMain()
+	m.StrictUTF8 = true
+	m.NoHeader = true
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`package foo; const s = "{{.}}"`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatal("unexpected write")
+		return nil
+	}
+
+	m.Paths = []string{"x.go.tmpl"}
+	m.Data = "\xfe"
+	if err := m.Run(); err == nil || err.Error() != `x.go: invalid UTF-8 at byte offset 24` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure outputs larger than the maximum size are not written.
+func TestMain_Run_MaxSize(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-max-size", "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := m.RunTemplate(`0123456789`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `0123456789` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatal("unexpected write")
+		return nil
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{range .}}0123456789{{end}}`), nil
+	}
+	m.Data = []int{1, 2}
+	if err := m.Run(); err == nil || err.Error() != `a: output size of 20 bytes exceeds -max-size of 10 bytes` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure templates can be parsed without data or writing output.
+func TestMain_Run_ValidateOnly(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-validate-only", "-prelude", "helpers.tmpl", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "helpers.tmpl":
+			return []byte(`{{define "name"}}{{.name | upper}}{{end}}`), nil
+		case "a.tmpl":
+			return []byte(`{{if .x}}`), nil
+		case "b.tmpl":
+			return []byte(`{{template "name" .}}`), nil
+		case "c.tmpl":
+			return []byte(`{{nosuchfunc}}`), nil
+		default:
+			t.Fatalf("unexpected read: %s", filename)
+			return nil, nil
+		}
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `2 templates failed to parse` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := m.Stderr.String(); s != "template: a.tmpl:1: unexpected EOF\n"+
+		"template: c.tmpl:1: function \"nosuchfunc\" not defined\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure errors from every path are reported in aggregate mode.
+func TestMain_Run_OnErrorAggregate(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-on-error", "aggregate", "a.go.tmpl", "b.tmpl", "c.go.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "a.go.tmpl":
+			return []byte(`package {{required "name is required" .name}}`), nil
+		case "b.tmpl":
+			return []byte(`b`), nil
+		default:
+			return []byte(`package {{`), nil
+		}
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `failed with 2 errors` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := m.Stderr.String(); s != `template: a.go.tmpl:1:10: executing "a.go.tmpl" at <required "name is required" .name>: error calling required: name is required`+"\n"+
+		`template: c.go.tmpl:1: unclosed action`+"\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	} else if !reflect.DeepEqual(written, map[string]string{"b": "b"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure an invalid error behavior is rejected.
+func TestMain_ParseFlags_ErrInvalidOnError(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-on-error", "ignore", "a.tmpl"}); err == nil || err.Error() != `invalid -on-error value: ignore` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a template that runs longer than the timeout is abandoned.
+func TestMain_Run_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-timeout", "10ms", "fast.tmpl", "slow.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Funcs = template.FuncMap{"wait": func() string { <-release; return "" }}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename == "slow.tmpl" {
+			return []byte(`a{{wait}}`), nil
+		}
+		return []byte(`fast`), nil
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `slow.tmpl: execution timed out after 10ms` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, map[string]string{"fast": "fast"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure an abandoned execution does not modify state used while later
+// templates are processed. Run with -race to detect regressions.
+func TestMain_Run_Timeout_Detached(t *testing.T) {
+	release := make(chan struct{})
+
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-timeout", "50ms", "-on-error", "aggregate", "-depfile", "deps.d", "slow.tmpl", "next.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Funcs = template.FuncMap{"wait": func() string { <-release; return "" }}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "slow.tmpl":
+			return []byte(`{{wait}}{{fileContents "secret.txt"}}`), nil
+		case "next.tmpl":
+			return []byte(`{{fileContents "other.txt"}}`), nil
+		case "other.txt":
+			// Let the abandoned execution continue while next.tmpl executes.
+			close(release)
+			time.Sleep(5 * time.Millisecond)
+		}
+		return []byte("x"), nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename)
+		return nil
+	}
+
+	if err := m.Run(); err == nil || err.Error() != `failed with 1 error` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(written, []string{"next"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure warnings cause the run to fail when treated as errors.
+func TestMain_Run_Werror(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-werror", "-on-missing-file", "skip", "a.tmpl", "b.tmpl", "c.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		if filename == "c.tmpl" {
+			return DefaultOSStat(filename)
 		}
-		return &fileInfo{mode: 0666}, nil
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) { return nil, nil }
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	if err := m.Run(); err == nil || err.Error() != `2 warnings treated as errors` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s := m.Stderr.String(); s != "warning: skipping missing file: a.tmpl\nwarning: skipping missing file: b.tmpl\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+
+	// Warnings from a previous run are not counted again.
+	m.OS.StatFn = DefaultOSStat
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure watch mode regenerates the templates that read a changed file.
+func TestMain_RunWatch(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-watch", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	files := map[string]string{"a.tmpl": `{{fileContents "partial.txt"}}`, "b.tmpl": "b", "partial.txt": "x"}
+	mtimes := map[string]int{"a.tmpl": 1, "b.tmpl": 1, "partial.txt": 1}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &fileInfo{mode: 0666, modTime: time.Unix(int64(mtimes[filename]), 0)}, nil
 	}
 	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
-		if filename != "a.tmpl" {
-			t.Fatalf("unexpected filename: %s", filename)
-		}
-		return []byte(`hi {{.name}}, you are {{.age}}`), nil
+		mu.Lock()
+		defer mu.Unlock()
+		return []byte(files[filename]), nil
+	}
+
+	var written []string
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written = append(written, filename+"="+string(data))
+		return nil
+	}
+
+	ticks, errc := make(chan time.Time), make(chan error)
+	go func() { errc <- m.RunWatch(ticks) }()
+
+	// Wait for the first run to finish before changing the partial. The
+	// change is picked up by one of the following ticks.
+	ticks <- time.Time{}
+	mu.Lock()
+	files["partial.txt"], mtimes["partial.txt"] = "y", 2
+	mu.Unlock()
+	ticks <- time.Time{}
+	ticks <- time.Time{}
+	close(ticks)
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, []string{"a=x", "b=b", "a=y"}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	} else if s := m.Stdout.String(); s != "regenerating a.tmpl: partial.txt changed\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+// Ensure watch mode cannot be used with options that describe a whole run.
+func TestMain_RunWatch_ErrManifest(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-watch", "-manifest", "manifest.json", "a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if err := m.RunWatch(nil); err == nil || err.Error() != `cannot use -watch with -manifest, -depfile, -incremental, -since, -verify, -diff, or -output-dir-temp` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure output is streamed to the file when no post-processing is required.
+func TestMain_Run_Stream(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{range .}}{{.}},{{end}}`), nil
 	}
 	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected buffered write: %s", filename)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	m.FileReadWriter.WriteFileFuncFn = func(filename string, perm os.FileMode, fn func(w io.Writer) error) error {
 		if filename != "a" {
 			t.Fatalf("unexpected filename: %s", filename)
-		} else if string(data) != `hi bob, you are 12` {
-			t.Fatalf("unexpected data: %s", data)
 		} else if perm != 0666 {
 			t.Fatalf("unexpected perm: %s", perm)
 		}
-		return nil
+		return fn(&buf)
 	}
+
 	m.Paths = []string{"a.tmpl"}
-	m.Data = map[string]interface{}{"name": "bob", "age": 12}
+	m.Data = []int{1, 2, 3}
 	if err := m.Run(); err != nil {
 		t.Fatal(err)
+	} else if s := buf.String(); s != `1,2,3,` {
+		t.Fatalf("unexpected output: %s", s)
 	}
 }
 
-// Ensure a file can be processed against array data.
-func TestMain_Run_Array(t *testing.T) {
+// Ensure a failing template leaves an existing output unchanged when streamed.
+func TestMain_Run_Stream_ErrExecute(t *testing.T) {
+	for _, source := range []string{
+		`hello {{fail "boom"}}`,
+
+		// Output has been flushed to the file before failing.
+		`{{repeat 8192 "x"}}{{fail "boom"}}`,
+	} {
+		if output, err := NewMain().RunTemplate(source, nil); err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Fatalf("unexpected error: %v", err)
+		} else if output != "" {
+			t.Fatalf("unexpected output written: %d bytes", len(output))
+		}
+	}
+}
+
+// Ensure streamed outputs replace the target of a symbolic link and keep the
+// mode of the existing file.
+func TestFileReadWriter_WriteFileFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tmpl-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target, link := filepath.Join(dir, "target"), filepath.Join(dir, "link")
+	if err := ioutil.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	} else if err := os.Symlink("target", link); err != nil {
+		t.Skip(err)
+	}
+
+	m := main.NewMain()
+	if err := m.FileReadWriter.WriteFileFunc(link, 0666, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	} else if buf, err := ioutil.ReadFile(target); err != nil || string(buf) != "new" {
+		t.Fatalf("unexpected target: %q, %v", buf, err)
+	} else if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected link to be kept: %v", err)
+	} else if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode: %v, %v", fi.Mode(), err)
+	}
+
+	// A failure leaves the file unchanged.
+	if err := m.FileReadWriter.WriteFileFunc(link, 0666, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("boom")
+	}); err == nil || err.Error() != "boom" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if buf, err := ioutil.ReadFile(target); err != nil || string(buf) != "new" {
+		t.Fatalf("unexpected target: %q, %v", buf, err)
+	} else if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 2 {
+		t.Fatalf("unexpected files: %d, %v", len(fis), err)
+	}
+}
+
+// Ensure paths can be processed concurrently.
+func TestMain_Run_Parallel(t *testing.T) {
 	m := NewMain()
+	if err := m.ParseFlags([]string{"-parallel", "4", "-manifest", "gen.json", "a.tmpl", "b.tmpl", "c.tmpl", "d.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
 	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
-		return []byte(`I like{{range .}} ({{.}}){{end}}`), nil
+		return []byte(filename), nil
 	}
+
+	var mu sync.Mutex
+	outputs := make(map[string]string)
 	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
-		if string(data) != `I like (apple) (pear)` {
-			t.Fatalf("unexpected data: %s", data)
+		mu.Lock()
+		defer mu.Unlock()
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(outputs, map[string]string{
+		"a":        "a.tmpl",
+		"b":        "b.tmpl",
+		"c":        "c.tmpl",
+		"d":        "d.tmpl",
+		"gen.json": outputs["gen.json"],
+	}) {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	} else if !regexp.MustCompile(`(?s)"a".*"b".*"c".*"d"`).MatchString(outputs["gen.json"]) {
+		t.Fatalf("unexpected manifest order: %s", outputs["gen.json"])
+	}
+}
+
+// Ensure log output of parallel workers can be written in path order.
+func TestMain_Run_ParallelOrder(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-parallel", "4", "-parallel-order", "-on-missing-file", "skip", "a.tmpl", "b.tmpl", "c.tmpl", "d.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Finish later paths first.
+	delays := map[string]time.Duration{"a.tmpl": 30, "b.tmpl": 20, "c.tmpl": 10, "d.tmpl": 0}
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		time.Sleep(delays[filename] * time.Millisecond)
+		return nil, os.ErrNotExist
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if s := m.Stderr.String(); s != "warning: skipping missing file: a.tmpl\n"+
+		"warning: skipping missing file: b.tmpl\n"+
+		"warning: skipping missing file: c.tmpl\n"+
+		"warning: skipping missing file: d.tmpl\n" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+// Ensure the first failed path's error is returned when run in parallel.
+func TestMain_Run_Parallel_Error(t *testing.T) {
+	m := NewMain()
+	m.Parallel = 2
+	m.OS.StatFn = func(filename string) (os.FileInfo, error) {
+		if filename == "a.tmpl" {
+			return DefaultOSStat(filename)
+		}
+		return nil, os.ErrNotExist
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte("X"), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	m.Paths = []string{"a.tmpl", "b.tmpl", "c.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `file not found` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure no further paths are processed in parallel once a path fails,
+// unless errors are aggregated.
+func TestMain_Run_Parallel_ErrStop(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		err     string
+		written []string
+	}{
+		{args: nil, err: `boom`, written: []string{"b"}},
+		{args: []string{"-on-error", "aggregate"}, err: `failed with 1 error`, written: []string{"b", "c", "d", "e"}},
+	} {
+		m := NewMain()
+		if err := m.ParseFlags(append(tt.args, "-parallel", "2", "a.tmpl", "b.tmpl", "c.tmpl", "d.tmpl", "e.tmpl")); err != nil {
+			t.Fatal(err)
+		}
+		m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+			switch filename {
+			case "a.tmpl":
+				return []byte(`{{fail "boom"}}`), nil
+			case "b.tmpl":
+				// Keep running after a.tmpl fails.
+				time.Sleep(20 * time.Millisecond)
+			}
+			return []byte("X"), nil
+		}
+
+		var mu sync.Mutex
+		var written []string
+		m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+			mu.Lock()
+			defer mu.Unlock()
+			written = append(written, filename)
+			return nil
+		}
+
+		if err := m.Run(); err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+			t.Fatalf("unexpected error: %v", err)
 		}
+		sort.Strings(written)
+		if !reflect.DeepEqual(written, tt.written) {
+			t.Fatalf("unexpected writes: %#v", written)
+		}
+	}
+}
+
+// Ensure calls to custom functions & outputs are serialized when parallel.
+// Run with -race to detect unsynchronized access.
+func TestMain_Run_Parallel_Funcs(t *testing.T) {
+	m := NewMain()
+	m.Parallel = 8
+	for i := 0; i < 32; i++ {
+		m.Paths = append(m.Paths, fmt.Sprintf("%d.tmpl", i))
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{count "a" 1 2}}`), nil
+	}
+
+	counts := make(map[string]int)
+	m.Funcs = template.FuncMap{
+		"count": func(key string, n ...int) int {
+			counts[key] += len(n)
+			return counts[key]
+		},
+	}
+	outputs := make(map[string]string)
+	m.Output = func(path string, data []byte, perm os.FileMode) error {
+		outputs[path] = string(data)
 		return nil
 	}
 
-	m.Paths = []string{"a.tmpl"}
-	m.Data = []interface{}{"apple", "pear"}
 	if err := m.Run(); err != nil {
 		t.Fatal(err)
+	} else if counts["a"] != 64 {
+		t.Fatalf("unexpected count: %d", counts["a"])
+	} else if len(outputs) != 32 {
+		t.Fatalf("unexpected output count: %d", len(outputs))
 	}
 }
 
-// Ensure a file will add a comment header if generating a Go file.
-func TestMain_Run_Header_Go(t *testing.T) {
+// Ensure custom functions are called concurrently when marked safe to.
+func TestMain_Run_Parallel_ConcurrentFuncs(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-parallel", "2", "-concurrent-funcs", "a.tmpl", "b.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{{wait}}`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+
+	// Each call waits for the other, which never happens if they are serialized.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	m.Funcs = template.FuncMap{
+		"wait": func() (string, error) {
+			done := make(chan struct{})
+			go func() { wg.Wait(); close(done) }()
+			wg.Done()
+			select {
+			case <-done:
+				return "", nil
+			case <-time.After(5 * time.Second):
+				return "", errors.New("calls were serialized")
+			}
+		},
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure built-in functions are safe to use concurrently.
+// Run with -race to detect unsynchronized access.
+func TestMain_Run_Parallel_Builtins(t *testing.T) {
 	m := NewMain()
+	if err := m.ParseFlags([]string{"-parallel", "8", "-seed", "1", "-data", `{"a":{"b":["x"]}}`}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 32; i++ {
+		m.Paths = append(m.Paths, fmt.Sprintf("%d.tmpl", i))
+	}
 	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
-		return []byte("\n\n\n\n\n\npackage foo"), nil
+		if filename == "x.txt" {
+			return []byte(" x "), nil
+		}
+		return []byte(`{{define "t"}}{{.}}{{end}}{{uuid}} {{goName "user_id"}} {{env "HOME" "none"}} {{tpl "t" 1}} ` +
+			`{{rawData}} {{fileContents "x.txt"}} {{jsonPointer . "/a/b/0"}} {{randAlpha 4}}`), nil
 	}
+
+	var mu sync.Mutex
+	outputs := make(map[string]string)
 	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
-		if string(data) != "// Generated by tmpl\n// https://github.com/benbjohnson/tmpl\n//\n// DO NOT EDIT!\n// Source: x.go.tmpl\n\npackage foo\n" {
-			t.Fatalf("unexpected data: %s", data)
+		mu.Lock()
+		defer mu.Unlock()
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if len(outputs) != 32 {
+		t.Fatalf("unexpected output count: %d", len(outputs))
+	} else if !strings.Contains(outputs["0"], ` UserID none 1 {"a":{"b":["x"]}} x x `) {
+		t.Fatalf("unexpected output: %s", outputs["0"])
+	}
+}
+
+// Ensure custom functions are available to templates & override built-ins.
+func TestMain_Run_Funcs(t *testing.T) {
+	m := NewMain()
+	m.Funcs = template.FuncMap{
+		"upper": func(s string) string { return "UP:" + s },
+		"twice": func(s string) string { return s + s },
+	}
+	if output, err := m.RunTemplate(`{{upper "a"}} {{twice "b"}}`, nil); err != nil {
+		t.Fatal(err)
+	} else if output != `UP:a bb` {
+		t.Fatalf("unexpected output: %s", output)
+	}
+}
+
+// Ensure outputs are passed through the formatter for their extension.
+func TestMain_Run_Format(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-format", ".json=jq .,.sql=pgformat", "a.json.tmpl", "b.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{ "a":1 }`), nil
+	}
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		if command != "jq ." {
+			t.Fatalf("unexpected command: %s", command)
+		} else if b, _ := ioutil.ReadAll(stdin); string(b) != `{ "a":1 }` {
+			t.Fatalf("unexpected stdin: %s", b)
 		}
+		io.WriteString(stdout, "{\n  \"a\": 1\n}\n")
 		return nil
 	}
 
-	m.Paths = []string{"x.go.tmpl"}
-	m.Data = []interface{}{"apple", "pear"}
+	outputs := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		outputs[filename] = string(data)
+		return nil
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(outputs, map[string]string{
+		"a.json": "{\n  \"a\": 1\n}\n",
+		"b.txt":  `{ "a":1 }`,
+	}) {
+		t.Fatalf("unexpected outputs: %#v", outputs)
+	}
+}
+
+// Ensure a failed formatter returns an error.
+func TestMain_Run_Format_Error(t *testing.T) {
+	m := NewMain()
+	m.Formatters = map[string]string{".json": "jq ."}
+	m.CommandRunner.RunCommandFn = func(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+		io.WriteString(stderr, "parse error\n")
+		return errors.New("exit status 5")
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`{`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		t.Fatalf("unexpected write: %s", filename)
+		return nil
+	}
+
+	m.Paths = []string{"a.json.tmpl"}
+	if err := m.Run(); err == nil || err.Error() != `a.json: formatter "jq .": exit status 5: parse error` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure an invalid formatter mapping returns an error.
+func TestMain_ParseFlags_Format_Invalid(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-format", "json=jq"}); err == nil || err.Error() != `invalid -format value: json=jq` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure Go output is buffered so that it can be formatted.
+func TestMain_Run_Stream_Go(t *testing.T) {
+	m := NewMain()
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		return []byte(`package foo`), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error { return nil }
+	m.FileReadWriter.WriteFileFuncFn = func(filename string, perm os.FileMode, fn func(w io.Writer) error) error {
+		t.Fatalf("unexpected stream: %s", filename)
+		return nil
+	}
+
+	m.Paths = []string{"a.go.tmpl"}
 	if err := m.Run(); err != nil {
 		t.Fatal(err)
 	}
@@ -127,6 +3478,7 @@ type Main struct {
 
 	OS             MainOS
 	FileReadWriter MainFileReadWriter
+	CommandRunner  MainCommandRunner
 
 	Stdin  bytes.Buffer
 	Stdout bytes.Buffer
@@ -139,6 +3491,7 @@ func NewMain() *Main {
 	m := &Main{Main: main.NewMain()}
 	m.Main.OS = &m.OS
 	m.Main.FileReadWriter = &m.FileReadWriter
+	m.Main.CommandRunner = &m.CommandRunner
 	m.Main.Stdin = &m.Stdin
 	m.Main.Stdout = &m.Stdout
 	m.Main.Stderr = &m.Stderr
@@ -148,27 +3501,88 @@ func NewMain() *Main {
 		m.Main.Stderr = io.MultiWriter(os.Stderr, m.Main.Stderr)
 	}
 
-	// Default stat() to use 0666.
+	// Default stat() to use 0666 & use an empty environment.
 	m.OS.StatFn = DefaultOSStat
+	m.OS.GetenvFn = func(key string) string { return "" }
 
 	return m
 }
 
+// RunTemplate processes source as the template file "a.tmpl" against data
+// and returns the generated output.
+func (m *Main) RunTemplate(source string, data interface{}) (string, error) {
+	var output string
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		if filename != "a.tmpl" {
+			return nil, os.ErrNotExist
+		}
+		return []byte(source), nil
+	}
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		output = string(data)
+		return nil
+	}
+
+	m.Paths = []string{"a.tmpl"}
+	m.Data = data
+	err := m.Run()
+	return output, err
+}
+
 // MainOS is a mockable implementation of Main.OS.
 type MainOS struct {
-	StatFn func(filename string) (os.FileInfo, error)
+	StatFn       func(filename string) (os.FileInfo, error)
+	GetenvFn     func(key string) string
+	ExecutableFn func() (string, error)
+	MkdirAllFn   func(path string, perm os.FileMode) error
+	ChmodFn      func(name string, mode os.FileMode) error
+	RemoveFn     func(name string) error
+	TempDirFn    func(dir, pattern string) (string, error)
+	ReadDirFn    func(dirname string) ([]os.FileInfo, error)
 }
 
 func (os *MainOS) Stat(filename string) (os.FileInfo, error) {
 	return os.StatFn(filename)
 }
 
+func (os *MainOS) Getenv(key string) string {
+	return os.GetenvFn(key)
+}
+
+func (os *MainOS) Executable() (string, error) {
+	return os.ExecutableFn()
+}
+
+func (os *MainOS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAllFn(path, perm)
+}
+
+func (os *MainOS) Chmod(name string, mode os.FileMode) error {
+	return os.ChmodFn(name, mode)
+}
+
+func (os *MainOS) Remove(name string) error {
+	return os.RemoveFn(name)
+}
+
+func (os *MainOS) TempDir(dir, pattern string) (string, error) {
+	return os.TempDirFn(dir, pattern)
+}
+
+func (os *MainOS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return os.ReadDirFn(dirname)
+}
+
 func DefaultOSStat(filename string) (os.FileInfo, error) { return &fileInfo{mode: 0666}, nil }
 
 // MainFileReadWriter is a mockable implementation of Main.FileReadWriter.
+//
+// If WriteFileFuncFn is not set then WriteFileFunc buffers the output of fn
+// and passes it to WriteFileFn if fn succeeds.
 type MainFileReadWriter struct {
-	ReadFileFn  func(filename string) ([]byte, error)
-	WriteFileFn func(filename string, data []byte, perm os.FileMode) error
+	ReadFileFn      func(filename string) ([]byte, error)
+	WriteFileFn     func(filename string, data []byte, perm os.FileMode) error
+	WriteFileFuncFn func(filename string, perm os.FileMode, fn func(w io.Writer) error) error
 }
 
 func (r *MainFileReadWriter) ReadFile(filename string) ([]byte, error) {
@@ -179,15 +3593,37 @@ func (r *MainFileReadWriter) WriteFile(filename string, data []byte, perm os.Fil
 	return r.WriteFileFn(filename, data, perm)
 }
 
+func (r *MainFileReadWriter) WriteFileFunc(filename string, perm os.FileMode, fn func(w io.Writer) error) error {
+	if r.WriteFileFuncFn != nil {
+		return r.WriteFileFuncFn(filename, perm, fn)
+	}
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return err
+	}
+	return r.WriteFileFn(filename, buf.Bytes(), perm)
+}
+
+// MainCommandRunner is a mockable implementation of Main.CommandRunner.
+type MainCommandRunner struct {
+	RunCommandFn func(command string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+func (r *MainCommandRunner) RunCommand(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return r.RunCommandFn(command, stdin, stdout, stderr)
+}
+
 type fileInfo struct {
-	mode os.FileMode
+	name    string
+	mode    os.FileMode
+	modTime time.Time
 }
 
-func (fi *fileInfo) Name() string       { return "" }
+func (fi *fileInfo) Name() string       { return fi.name }
 func (fi *fileInfo) Size() int64        { return 0 }
 func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
-func (fi *fileInfo) ModTime() time.Time { return time.Time{} }
-func (fi *fileInfo) IsDir() bool        { return false }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
 func (fi *fileInfo) Sys() interface{}   { return nil }
 
 // MustTempDir returns a temporary directory. Panic on error.
@@ -221,3 +3657,61 @@ func MustReadFile(filename string) []byte {
 	}
 	return data
 }
+
+// Ensure template names are resolved against the template directory and
+// generated to the output directory.
+func TestMain_Run_TemplateDir(t *testing.T) {
+	m := NewMain()
+	if err := m.ParseFlags([]string{"-template-dir", "templates", "-outdir", "gen", "user.go", "post.go.tmpl", "sub/a.txt.tmpl"}); err != nil {
+		t.Fatal(err)
+	}
+	m.OS.MkdirAllFn = func(path string, perm os.FileMode) error {
+		if path != "gen" {
+			t.Fatalf("unexpected path: %s", path)
+		}
+		return nil
+	}
+	m.FileReadWriter.ReadFileFn = func(filename string) ([]byte, error) {
+		switch filename {
+		case "templates/user.go.tmpl":
+			return []byte(`package gen; type User struct{}`), nil
+		case "templates/post.go.tmpl":
+			return []byte(`package gen; type Post struct{}`), nil
+		case "sub/a.txt.tmpl":
+			return []byte(`a`), nil
+		default:
+			t.Fatalf("unexpected read: %s", filename)
+			return nil, nil
+		}
+	}
+
+	written := make(map[string]string)
+	m.FileReadWriter.WriteFileFn = func(filename string, data []byte, perm os.FileMode) error {
+		written[filename] = string(data)
+		return nil
+	}
+
+	m.NoHeader = true
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(written, map[string]string{
+		"gen/user.go": "package gen\n\ntype User struct{}\n",
+		"gen/post.go": "package gen\n\ntype Post struct{}\n",
+		"sub/a.txt":   "a",
+	}) {
+		t.Fatalf("unexpected writes: %#v", written)
+	}
+}
+
+// Ensure template names are not resolved against the executable's directory.
+func TestMain_ParseFlags_TemplateDir_RelativeToExe(t *testing.T) {
+	m := NewMain()
+	m.OS.ExecutableFn = func() (string, error) { return "/opt/tmpl/bin/tmpl", nil }
+	if err := m.ParseFlags([]string{"-relative-to-exe", "-template-dir", "templates", "user.go", "sub/a.tmpl"}); err != nil {
+		t.Fatal(err)
+	} else if m.TemplateDir != "/opt/tmpl/bin/templates" {
+		t.Fatalf("unexpected template dir: %s", m.TemplateDir)
+	} else if !reflect.DeepEqual(m.Paths, []string{"user.go", "/opt/tmpl/bin/sub/a.tmpl"}) {
+		t.Fatalf("unexpected paths: %+v", m.Paths)
+	}
+}
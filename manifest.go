@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Manifest represents a list of files generated by a run.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile represents a single generated file and a hash of its contents.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// newManifestFile returns a manifest entry for data written to path.
+func newManifestFile(path string, data []byte) ManifestFile {
+	sum := sha256.Sum256(data)
+	return ManifestFile{Path: path, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// writeManifest writes the files generated during the run to ManifestPath.
+func (m *Main) writeManifest() error {
+	manifest := Manifest{Files: m.generated}
+	if manifest.Files == nil {
+		manifest.Files = []ManifestFile{}
+	}
+
+	buf, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return m.writeOutput(m.ManifestPath, append(buf, '\n'), 0666)
+}
+
+// prune removes files listed in the previous manifest at ManifestPath that
+// were not generated by this run. Files that have been modified since they
+// were generated, and so no longer match their hash, are kept with a warning.
+func (m *Main) prune() error {
+	buf, err := m.FileReadWriter.ReadFile(m.ManifestPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var prev Manifest
+	if err := json.Unmarshal(buf, &prev); err != nil {
+		return fmt.Errorf("prune: invalid manifest %s: %s", m.ManifestPath, err)
+	}
+
+	generated := make(map[string]bool)
+	for _, f := range m.generated {
+		generated[filepath.Clean(f.Path)] = true
+	}
+
+	for _, f := range prev.Files {
+		if generated[filepath.Clean(f.Path)] {
+			continue
+		}
+
+		// Only remove files whose contents match what was generated.
+		buf, err := m.FileReadWriter.ReadFile(f.Path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		} else if newManifestFile(f.Path, buf).SHA256 != f.SHA256 {
+			m.warnf("prune: not removing %s as it was modified after it was generated", f.Path)
+			continue
+		}
+
+		if err := m.OS.Remove(f.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
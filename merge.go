@@ -0,0 +1,49 @@
+package main
+
+// Strategies for combining arrays when merging data from multiple sources.
+const (
+	MergeReplace      = "replace"
+	MergeAppend       = "append"
+	MergeUniqueAppend = "unique-append"
+)
+
+// mergeData deeply merges src into dst and returns the result. Maps are
+// merged recursively, with values from src taking precedence. Arrays are
+// combined according to strategy and all other values are replaced by src.
+// Neither dst nor src is modified.
+func mergeData(dst, src interface{}, strategy string) interface{} {
+	if dm, ok := dst.(map[string]interface{}); ok {
+		if sm, ok := src.(map[string]interface{}); ok {
+			other := make(map[string]interface{}, len(dm)+len(sm))
+			for k, v := range dm {
+				other[k] = v
+			}
+			for k, v := range sm {
+				if dv, ok := other[k]; ok {
+					v = mergeData(dv, v, strategy)
+				}
+				other[k] = v
+			}
+			return other
+		}
+	}
+
+	da, sa := toList(dst), toList(src)
+	if da == nil || sa == nil {
+		return src
+	}
+
+	switch strategy {
+	case MergeAppend:
+		return append(da, sa...)
+	case MergeUniqueAppend:
+		for _, v := range sa {
+			if !hasItem(da, v) {
+				da = append(da, v)
+			}
+		}
+		return da
+	default:
+		return src
+	}
+}
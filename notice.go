@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode"
+)
+
+// Styles of generated file notice for outputs that cannot have a comment
+// header.
+const (
+	// NoticeKey adds a "_generated": true key to a JSON object output.
+	NoticeKey = "key"
+
+	// NoticeFile writes the notice to a sibling file with a ".generated"
+	// extension appended to the output path.
+	NoticeFile = "file"
+)
+
+// GeneratedKey is the key added to JSON object outputs by NoticeKey.
+const GeneratedKey = "_generated"
+
+// noticeStyle returns the generated notice style for outputPath, if any.
+func (m *Main) noticeStyle(outputPath string) string {
+	return m.GeneratedNotices[filepath.Ext(outputPath)]
+}
+
+// insertGeneratedKey inserts GeneratedKey as the first key of the JSON object
+// in output. The whitespace preceding the object's first key is repeated so
+// that indentation is preserved.
+func insertGeneratedKey(outputPath string, output []byte) ([]byte, error) {
+	i := bytes.IndexFunc(output, func(r rune) bool { return !unicode.IsSpace(r) })
+	if i == -1 || output[i] != '{' {
+		return nil, fmt.Errorf("%s: -generated-notice %s requires a JSON object", outputPath, NoticeKey)
+	}
+
+	rest := output[i+1:]
+	j := bytes.IndexFunc(rest, func(r rune) bool { return !unicode.IsSpace(r) })
+	if j == -1 {
+		j = len(rest)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(output[:i+1])
+	buf.Write(rest[:j])
+	fmt.Fprintf(&buf, "%q: true", GeneratedKey)
+	if j < len(rest) && rest[j] != '}' {
+		buf.WriteByte(',')
+		buf.Write(rest[:j])
+	}
+	buf.Write(rest[j:])
+	return buf.Bytes(), nil
+}
+
+// writeNoticeFile writes the generated notice for the output at outputPath,
+// generated from the template at path, to a sibling file.
+func (m *Main) writeNoticeFile(path, outputPath string) error {
+	noticePath := outputPath + ".generated"
+	buf := []byte(fmt.Sprintf("%s\nDO NOT EDIT!\nSource: %s\n", m.headerLine(), path))
+	if err := m.writeOutput(noticePath, buf, 0666); err != nil {
+		return err
+	}
+	m.generated = append(m.generated, newManifestFile(noticePath, buf))
+	return nil
+}
+
+// hasNotice returns true if the existing output at path, with contents buf,
+// has a generated notice in the style configured for it.
+func (m *Main) hasNotice(path string, buf []byte) (bool, error) {
+	switch m.noticeStyle(path) {
+	case NoticeKey:
+		var v map[string]interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return false, nil
+		}
+		return v[GeneratedKey] == true, nil
+	case NoticeFile:
+		notice, err := m.FileReadWriter.ReadFile(path + ".generated")
+		if os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return m.isGenerated(notice), nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// keyOrders records the source order of the keys of decoded JSON objects,
+// keyed by the identity of the map each was decoded into. Each map is kept
+// alongside its keys so that its address cannot be reused by another map
+// while it is recorded. It is shared by parallel workers so it is safe for
+// concurrent use.
+type keyOrders struct {
+	mu sync.Mutex
+	m  map[uintptr]keyOrder
+}
+
+// keyOrder is a decoded JSON object and the source order of its keys.
+type keyOrder struct {
+	m    map[string]interface{}
+	keys []string
+}
+
+// record walks the JSON tokens in buf alongside v, the value decoded from
+// buf, and records the key order of each object in v.
+func (o *keyOrders) record(buf []byte, v interface{}) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.m == nil {
+		o.m = make(map[uintptr]keyOrder)
+	}
+	return o.walk(json.NewDecoder(bytes.NewReader(buf)), v)
+}
+
+func (o *keyOrders) walk(dec *json.Decoder, v interface{}) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m, _ := v.(map[string]interface{})
+		var keys []string
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			if err := o.walk(dec, m[key]); err != nil {
+				return err
+			}
+
+			// Duplicate keys keep the position of their first occurrence.
+			if !seen[key] {
+				keys, seen[key] = append(keys, key), true
+			}
+		}
+		if m != nil {
+			o.m[reflect.ValueOf(m).Pointer()] = keyOrder{m: m, keys: keys}
+		}
+	case json.Delim('['):
+		a, _ := v.([]interface{})
+		for i := 0; dec.More(); i++ {
+			var elem interface{}
+			if i < len(a) {
+				elem = a[i]
+			}
+			if err := o.walk(dec, elem); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	// Consume closing delimiter.
+	_, err = dec.Token()
+	return err
+}
+
+// reset forgets the key order of all recorded maps.
+func (o *keyOrders) reset() {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.m = nil
+}
+
+// keys returns the recorded key order of m. Returns false if no order was
+// recorded for m or if m's keys no longer match those recorded.
+func (o *keyOrders) keys(m map[string]interface{}) ([]string, bool) {
+	if o == nil {
+		return nil, false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	order, ok := o.m[reflect.ValueOf(m).Pointer()]
+	if !ok || len(order.keys) != len(m) {
+		return nil, false
+	}
+	for _, k := range order.keys {
+		if _, ok := m[k]; !ok {
+			return nil, false
+		}
+	}
+	return order.keys, true
+}
+
+// ordered returns the entries of the map v in the order their keys appeared
+// in the JSON source it was decoded from, with an index counting from zero.
+// Key order is only recorded when OrderedData is set. Maps with fewer than
+// two keys are always in order.
+func (m *Main) ordered(v interface{}) ([]EnumItem, error) {
+	mv, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ordered: expected map, got %T", v)
+	}
+
+	var keys []string
+	if len(mv) < 2 {
+		for k := range mv {
+			keys = append(keys, k)
+		}
+	} else if !m.OrderedData {
+		return nil, errors.New("ordered: key order is only recorded with -ordered-data")
+	} else if keys, ok = m.keyOrders.keys(mv); !ok {
+		return nil, errors.New("ordered: key order is unknown; only maps decoded from JSON data keep their order")
+	}
+
+	a := make([]EnumItem, len(keys))
+	for i, k := range keys {
+		a[i] = EnumItem{Index: i, Key: k, Value: mv[k]}
+	}
+	return a, nil
+}
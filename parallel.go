@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// processParallel processes Paths using up to Parallel concurrent workers.
+//
+// Each path is processed by a copy of m so that generated files, warnings,
+// and, if ParallelOrder is set, log output can be merged back in path order
+// once all workers are done. Returns the error for the first failed path,
+// unless errors are aggregated. As when processing serially, no further paths
+// are started once a path fails unless errors are aggregated.
+//
+// Built-in functions are safe for concurrent use. Unless ConcurrentFuncs is
+// set, calls to Funcs and Output are serialized as they may share state.
+func (m *Main) processParallel() error {
+	// Resolve colorization before workers replace Stderr.
+	color := ColorNever
+	if m.useColor() {
+		color = ColorAlways
+	}
+	stdout, stderr := &syncWriter{w: m.Stdout}, &syncWriter{w: m.Stderr}
+	rand := &syncReader{r: m.Rand}
+	funcs, output := m.Funcs, m.Output
+	if !m.ConcurrentFuncs {
+		var mu sync.Mutex
+		funcs = syncFuncs(&mu, funcs)
+		if output != nil {
+			output = func(path string, data []byte, perm os.FileMode) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return m.Output(path, data, perm)
+			}
+		}
+	}
+
+	workers := make([]*Main, len(m.Paths))
+	logs := make([]bytes.Buffer, len(m.Paths))
+	errs := make([]error, len(m.Paths))
+
+	var wg sync.WaitGroup
+	var failed int32
+	sem := make(chan struct{}, m.Parallel)
+	for i, path := range m.Paths {
+		// Wait for a free worker & stop if a path has failed meanwhile.
+		sem <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			<-sem
+			break
+		}
+
+		w := *m
+		w.generated, w.outOfDate, w.warnings = nil, nil, 0
+		w.Color, w.Rand, w.Stdout, w.Stderr = color, rand, stdout, stderr
+		w.Funcs, w.Output = funcs, output
+		if m.ParallelOrder {
+			w.Stderr = &logs[i]
+		}
+		workers[i] = &w
+
+		wg.Add(1)
+		go func(i int, path string) {
+			defer func() { <-sem; wg.Done() }()
+			if errs[i] = workers[i].process(path); errs[i] != nil && m.OnError != OnErrorAggregate {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i, path)
+	}
+	wg.Wait()
+
+	// Merge results of the workers that were started in path order.
+	for i, w := range workers {
+		if w == nil {
+			break
+		}
+		if _, err := m.Stderr.Write(logs[i].Bytes()); err != nil {
+			return err
+		}
+		m.generated = append(m.generated, w.generated...)
+		m.written = append(m.written, w.written...)
+		m.dependencies = append(m.dependencies, w.dependencies...)
+		m.addWatched(m.Paths[i], w.inputs)
+		m.outOfDate = append(m.outOfDate, w.outOfDate...)
+		m.warnings += w.warnings
+	}
+
+	for _, err := range errs {
+		if err := m.aggregateError(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncFuncs returns a copy of funcs where each function holds mu while it is
+// called. Values that are not functions are copied unchanged.
+func syncFuncs(mu *sync.Mutex, funcs template.FuncMap) template.FuncMap {
+	if funcs == nil {
+		return nil
+	}
+
+	other := make(template.FuncMap, len(funcs))
+	for name, fn := range funcs {
+		rv := reflect.ValueOf(fn)
+		if rv.Kind() != reflect.Func {
+			other[name] = fn
+			continue
+		}
+		other[name] = reflect.MakeFunc(rv.Type(), func(args []reflect.Value) []reflect.Value {
+			mu.Lock()
+			defer mu.Unlock()
+			if rv.Type().IsVariadic() {
+				return rv.CallSlice(args)
+			}
+			return rv.Call(args)
+		}).Interface()
+	}
+	return other
+}
+
+// syncWriter serializes writes to an underlying writer.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// syncReader serializes reads from an underlying reader.
+type syncReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (r *syncReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Read(p)
+}
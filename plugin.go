@@ -0,0 +1,30 @@
+//go:build (linux || darwin || freebsd) && cgo
+// +build linux darwin freebsd
+// +build cgo
+
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"text/template"
+)
+
+// loadPlugin opens the Go plugin at path and returns the functions returned
+// by its exported Funcs function.
+func loadPlugin(path string) (template.FuncMap, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %s", path, err)
+	}
+
+	sym, err := p.Lookup("Funcs")
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: no exported Funcs function", path)
+	}
+	fn, ok := sym.(func() template.FuncMap)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: Funcs must be a func() template.FuncMap, got %T", path, sym)
+	}
+	return fn(), nil
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd) || !cgo
+// +build !linux,!darwin,!freebsd !cgo
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"text/template"
+)
+
+// loadPlugin returns an error as Go plugins are not supported on this
+// platform, or without cgo.
+func loadPlugin(path string) (template.FuncMap, error) {
+	return nil, fmt.Errorf("plugin %s: plugins are not supported on %s/%s or without cgo", path, runtime.GOOS, runtime.GOARCH)
+}
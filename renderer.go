@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Renderer renders a template that has been parsed once so that it can be
+// executed repeatedly against different data without reparsing. Each
+// template processed by Run is also rendered through a Renderer.
+type Renderer struct {
+	m          *Main
+	name       string
+	outputPath string
+	tmpl       *template.Template
+}
+
+// NewRenderer parses the template source with the functions and options of
+// m. The name is used in error messages and determines the output type in the
+// same way as a template path, so a name of "a.go.tmpl" renders with a Go
+// header and is formatted.
+func (m *Main) NewRenderer(name string, source []byte) (*Renderer, error) {
+	tmpl, err := m.parse(name, source)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		m:          m,
+		name:       name,
+		outputPath: strings.TrimSuffix(name, Extension),
+		tmpl:       tmpl,
+	}, nil
+}
+
+// Execute executes the template against data and returns the output.
+func (r *Renderer) Execute(data interface{}) ([]byte, error) {
+	output, err := r.render(r.outputPath, data)
+	if err != nil {
+		return nil, err
+	} else if filepath.Ext(r.outputPath) == ".go" {
+		return r.m.formatGo(r.outputPath, output)
+	}
+	return output, nil
+}
+
+// lookup returns a Renderer for the template defined as name in the same
+// source. Errors and headers still refer to the source by its name.
+func (r *Renderer) lookup(name string) *Renderer {
+	other := *r
+	other.tmpl = r.tmpl.Lookup(name)
+	return &other
+}
+
+// render executes the template against data and returns the unformatted
+// output, including a header if one is required for outputPath.
+func (r *Renderer) render(outputPath string, data interface{}) ([]byte, error) {
+	m := r.m
+
+	// Execute template.
+	var body bytes.Buffer
+	if err := m.execute(&body, r.tmpl, data); err != nil {
+		return nil, err
+	}
+
+	// Remove source line markers, which are kept in Go output until it is
+	// formatted.
+	output := body.Bytes()
+	if m.LineDirectives && filepath.Ext(outputPath) != ".go" {
+		output, _ = stripLineMarkers(output)
+	}
+
+	// Remove runs of blank lines, if requested.
+	if m.CollapseBlankLines {
+		output = collapseBlankLines(output)
+	}
+
+	// Mark JSON output as generated with a key, if requested.
+	if m.noticeStyle(outputPath) == NoticeKey {
+		var err error
+		if output, err = insertGeneratedKey(outputPath, output); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create a comment at the top if the output type requires one.
+	// The header is written after any interpreter line so it keeps working.
+	var buf bytes.Buffer
+	if prefix := m.headerCommentPrefix(outputPath); prefix != "" {
+		if bytes.HasPrefix(output, []byte("#!")) {
+			i := bytes.IndexByte(output, '\n') + 1
+			if i == 0 {
+				i = len(output)
+			}
+			buf.Write(output[:i])
+			output = output[i:]
+		}
+		fmt.Fprintln(&buf, prefix, m.headerLine())
+		fmt.Fprintln(&buf, prefix, "https://github.com/benbjohnson/tmpl")
+		fmt.Fprintln(&buf, prefix)
+		fmt.Fprintln(&buf, prefix, "DO NOT EDIT!")
+		fmt.Fprintln(&buf, prefix, "Source:", r.name)
+		fmt.Fprintln(&buf, "")
+	}
+	buf.Write(output)
+
+	return buf.Bytes(), nil
+}
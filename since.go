@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadChangedFiles lists the files changed since the git ref Since, including
+// untracked files, and stores them for isChanged. If git fails, such as when
+// it is not installed, a warning is written and every template is processed.
+func (m *Main) loadChangedFiles() {
+	m.changed = nil
+
+	command := "git diff --name-only --relative " + shellQuote(m.Since) + " -- && git ls-files --others --exclude-standard"
+	buf, err := m.runCommand(command, nil)
+	if err != nil {
+		m.warnf("-since: cannot list changed files, processing all templates: %s", err)
+		return
+	}
+
+	changed := make(map[string]bool)
+	for _, line := range lines(string(buf)) {
+		if line != "" {
+			changed[absPath(filepath.FromSlash(line))] = true
+		}
+	}
+
+	// Every template depends on the shared data & prelude files.
+	shared := append([]string{m.PreludePath}, m.DataPaths...)
+	for _, path := range shared {
+		if path != "" && changed[absPath(path)] {
+			return
+		}
+	}
+	m.changed = changed
+}
+
+// isChanged returns true if the template at path, its data file, or an input
+// recorded for outputPath in the previous depfile changed since Since, or if
+// the output does not exist yet. Returns true if changed files are unknown.
+func (m *Main) isChanged(path, outputPath string) (bool, error) {
+	if m.changed == nil || m.changed[absPath(path)] {
+		return true, nil
+	} else if m.TemplateDataPath != "" && m.changed[absPath(filepath.Join(filepath.Dir(path), m.TemplateDataPath))] {
+		return true, nil
+	}
+	for _, input := range m.recorded[outputPath] {
+		if m.changed[absPath(input)] {
+			return true, nil
+		}
+	}
+
+	if _, err := m.OS.Stat(outputPath); os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// absPath returns the absolute form of path, or the cleaned path if the
+// working directory cannot be determined.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// shellQuote quotes s as a single argument for the system shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// generateSplit executes r once for each item of the array under the SplitBy
+// key in data. Each item is written to the path generated by executing the
+// SplitName template against it, relative to the template's directory.
+// Returns an error if an item's path is outside the template's directory or
+// two items generate the same path.
+func (m *Main) generateSplit(r *Renderer, data interface{}, perm os.FileMode) error {
+	path := r.name
+
+	items := toList(fieldValue(data, m.SplitBy))
+	if items == nil {
+		return fmt.Errorf("-split-by: %q is not an array", m.SplitBy)
+	}
+
+	nameTmpl, err := template.New("split-name").Funcs(m.funcMap()).Parse(m.SplitName)
+	if err != nil {
+		return fmt.Errorf("-split-name: %s", err)
+	}
+
+	paths := make(map[string]bool)
+	for _, item := range items {
+		// Generate output path from item.
+		var buf bytes.Buffer
+		if err := nameTmpl.Execute(&buf, item); err != nil {
+			return fmt.Errorf("-split-name: %s", err)
+		} else if buf.Len() == 0 {
+			return fmt.Errorf("-split-name: empty output path for item: %v", item)
+		} else if !isLocalPath(buf.String()) {
+			return fmt.Errorf("-split-name: output path is outside the template directory: %s", buf.String())
+		}
+		outputPath := filepath.Join(filepath.Dir(path), buf.String())
+
+		// Ensure each item is written to a separate file.
+		if paths[outputPath] {
+			return fmt.Errorf("-split-name: multiple items generate output path: %s", outputPath)
+		}
+		paths[outputPath] = true
+
+		if err := m.generate(r, outputPath, item, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
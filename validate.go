@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize/english"
+)
+
+// validateAll parses the inline template, the data directory template, and
+// each path without rendering them. Each failure is written to Stderr and an
+// error is returned if any template failed to parse.
+func (m *Main) validateAll() error {
+	var n int
+	if m.TemplateString != "" {
+		if _, err := m.NewRenderer(TemplateStringName, []byte(m.TemplateString)); err != nil {
+			m.PrintError(err)
+			n++
+		}
+	}
+
+	paths := make([]string, 0, len(m.Paths)+1)
+	if m.DataDir != "" {
+		paths = append(paths, m.TemplatePath)
+	}
+	for _, path := range m.Paths {
+		path, _ := m.templatePaths(path)
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if _, _, err := m.readTemplate(path); err != nil {
+			m.PrintError(err)
+			n++
+		}
+	}
+
+	if n > 0 {
+		return fmt.Errorf("%s failed to parse", english.Plural(n, "template", ""))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RunWatch runs the program and then, on each tick until ticks is closed,
+// reprocesses the paths whose template, prelude, or other inputs, such as
+// files read by template functions, have changed. Each reprocessed path is
+// written to Stdout with the inputs that changed. Errors while reprocessing
+// are written to Stderr instead of stopping the watch.
+//
+// Data passed with -data or -data-cmd is only read once.
+func (m *Main) RunWatch(ticks <-chan time.Time) error {
+	if len(m.Paths) == 0 || m.JSONL {
+		return errors.New("-watch requires paths and cannot be used with -jsonl")
+	} else if m.ManifestPath != "" || m.DepfilePath != "" || m.Incremental || m.Since != "" || m.comparing() || m.OutputDirTemp {
+		// These either describe every output of a run or skip outputs
+		// whose inputs are only known from a previous run.
+		return errors.New("cannot use -watch with -manifest, -depfile, -incremental, -since, -verify, -diff, or -output-dir-temp")
+	}
+
+	// Record the inputs of each path as it is processed.
+	m.Watch = true
+	if err := m.Run(); err != nil {
+		return err
+	}
+
+	modTimes := make(map[string]time.Time)
+	m.changedInputs(modTimes)
+	for range ticks {
+		m.processChanged(modTimes)
+	}
+	return nil
+}
+
+// processChanged reprocesses each path with an input modified since its time
+// in modTimes and runs PostHook on the files written, if any.
+func (m *Main) processChanged(modTimes map[string]time.Time) {
+	changed := m.changedInputs(modTimes)
+	if len(changed) == 0 {
+		return
+	}
+
+	m.written, m.prelude = nil, nil
+	for _, path := range m.Paths {
+		var inputs []string
+		for _, input := range m.watched[path] {
+			if changed[input] {
+				inputs = append(inputs, input)
+			}
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(m.Stdout, "regenerating %s: %s changed\n", path, strings.Join(inputs, ", "))
+		err := m.process(path)
+		m.addWatched(path, m.inputs)
+		if err != nil {
+			m.PrintError(err)
+		}
+	}
+
+	// Inputs read for the first time are compared against their current
+	// modification time from now on.
+	for _, path := range m.Paths {
+		for _, input := range m.watched[path] {
+			if _, ok := modTimes[input]; !ok {
+				modTimes[input] = m.modTime(input)
+			}
+		}
+	}
+
+	if m.PostHook != "" && len(m.written) > 0 {
+		if err := m.runPostHook(); err != nil {
+			m.PrintError(err)
+		}
+	}
+}
+
+// changedInputs returns the watched inputs whose modification time differs
+// from their time in modTimes, which is updated. Inputs not yet in modTimes
+// are added without being reported. Missing inputs have a zero time.
+func (m *Main) changedInputs(modTimes map[string]time.Time) map[string]bool {
+	changed := make(map[string]bool)
+	for _, path := range m.Paths {
+		for _, input := range m.watched[path] {
+			modTime := m.modTime(input)
+			if prev, ok := modTimes[input]; ok && !prev.Equal(modTime) {
+				changed[input] = true
+			}
+			modTimes[input] = modTime
+		}
+	}
+	return changed
+}
+
+// modTime returns the modification time of the file at path, or the zero
+// time if it cannot be read.
+func (m *Main) modTime(path string) time.Time {
+	fi, err := m.OS.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// addWatched records the inputs read while processing path, along with its
+// template file and the prelude, so that path is reprocessed when any of
+// them change.
+func (m *Main) addWatched(path string, read []string) {
+	if !m.Watch {
+		return
+	}
+
+	tmplPath, _ := m.templatePaths(path)
+	var inputs []string
+	seen := make(map[string]bool)
+	for _, input := range append([]string{tmplPath, m.PreludePath}, read...) {
+		if input != "" && !seen[input] {
+			inputs, seen[input] = append(inputs, input), true
+		}
+	}
+
+	if m.watched == nil {
+		m.watched = make(map[string][]string)
+	}
+	m.watched[path] = inputs
+}